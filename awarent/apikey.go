@@ -0,0 +1,150 @@
+package awarent
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//api key modes of resource
+const (
+	//APIKeyReplace valid api key replaces ip authorization of resource
+	APIKeyReplace = "replace"
+	//APIKeyCombine both valid api key and ip authorization are required
+	APIKeyCombine = "combine"
+
+	defaultAPIKeyHeader = "X-API-Key"
+	apiKeyContextKey    = "awarent.apiKey"
+)
+
+//APIKeyOptions per resource api keys checked by APIKeyAuth middleware. key is read from Header(X-API-Key by default),
+//then from query param Query if configured
+type APIKeyOptions struct {
+	Header string   `yaml:"header"`
+	Query  string   `yaml:"query"`
+	Keys   []APIKey `yaml:"keys"`
+}
+
+//APIKey api keys of resource. Hashes are hex encoded sha256 of keys, optionally prefixed with sha256:,
+//several hashes are allowed for key rotation. Mode is replace(default) or combine
+type APIKey struct {
+	Resource string   `yaml:"resource"`
+	Hashes   []string `yaml:"hashes"`
+	Mode     string   `yaml:"mode"`
+}
+
+type apiKeyEntry struct {
+	hashes  [][]byte
+	combine bool
+}
+
+//apiKeyTable api keys of resource
+type apiKeyTable map[string]*apiKeyEntry
+
+//newAPIKeyTable compile api keys, invalid hash is collected as warning
+func newAPIKeyTable(keys []APIKey) (apiKeyTable, []string) {
+	table := apiKeyTable{}
+	var warnings []string
+	for _, key := range keys {
+		if len(key.Resource) == 0 {
+			warnings = append(warnings, "api key without resource")
+			continue
+		}
+		mode := strings.ToLower(key.Mode)
+		if mode != "" && mode != APIKeyReplace && mode != APIKeyCombine {
+			warnings = append(warnings, fmt.Sprintf("api key mode %q of resource %s is invalid", key.Mode, key.Resource))
+			continue
+		}
+		entry := table[key.Resource]
+		if entry == nil {
+			entry = &apiKeyEntry{}
+			table[key.Resource] = entry
+		}
+		entry.combine = entry.combine || mode == APIKeyCombine
+		for _, hash := range key.Hashes {
+			sum, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(hash), "sha256:"))
+			if err != nil || len(sum) != sha256.Size {
+				warnings = append(warnings, fmt.Sprintf("api key hash of resource %s is not hex sha256", key.Resource))
+				continue
+			}
+			entry.hashes = append(entry.hashes, sum)
+		}
+	}
+	return table, warnings
+}
+
+//verify whether key is one of api keys of resource
+func (e *apiKeyEntry) verify(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(key))
+	matched := 0
+	for _, hash := range e.hashes {
+		matched |= subtle.ConstantTimeCompare(sum[:], hash)
+	}
+	return matched == 1
+}
+
+//replaces whether verified api key of resource replaces ip authorization
+func (t apiKeyTable) replaces(resource string) bool {
+	entry, ok := t[resource]
+	return ok && !entry.combine
+}
+
+//apiKey api key of request, header is preferred to query
+func (opts *APIKeyOptions) apiKey(c *gin.Context) string {
+	header := opts.Header
+	if len(header) == 0 {
+		header = defaultAPIKeyHeader
+	}
+	if key := c.GetHeader(header); len(key) > 0 {
+		return key
+	}
+	if len(opts.Query) > 0 {
+		return c.Query(opts.Query)
+	}
+	return ""
+}
+
+//APIKeyAuth api key authentication of resources configured with api keys, requests of other resources pass through.
+//use it before IPFilter so that verified key replaces ip authorization of the resource in replace mode.
+//missing or invalid key is rejected with 401 and observed for automatic bans, banned ips are rejected before key verification
+func (a *Awarent) APIKeyAuth() gin.HandlerFunc {
+	return a.withBans(func(c *gin.Context) {
+		s := a.requestSnapshot(c)
+		_, param, ok := s.ipTarget(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		entry, ok := s.filter.apiKeys[param]
+		if !ok {
+			c.Next()
+			return
+		}
+		key := s.rule.IPFilterRules.APIKeys.apiKey(c)
+		if !entry.verify(key) {
+			rule := "invalid api key"
+			if len(key) == 0 {
+				rule = "missing api key"
+			}
+			s.audit.record(newAuditEvent(c, time.Now(), a.clientIP(c), param, Decision{Reason: ReasonAPIKey, Rule: rule}))
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set(apiKeyContextKey, param)
+		c.Next()
+	})
+}
+
+//apiKeyVerified whether request carries verified api key of resource
+func apiKeyVerified(c *gin.Context, resource string) bool {
+	return len(resource) > 0 && c.GetString(apiKeyContextKey) == resource
+}
@@ -0,0 +1,114 @@
+package awarent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		IPFilterRules: FilterOptions{
+			URLPath:  "/q",
+			URLParam: "cid",
+			AuthorizedIPs: []Authorized{
+				{Resource: "ipcid", IPS: []string{"192.0.2.1"}},
+				{Resource: "both", IPS: []string{"192.0.2.1"}},
+			},
+			APIKeys: APIKeyOptions{
+				Query: "apiKey",
+				Keys: []APIKey{
+					{Resource: "nat", Hashes: []string{"sha256:" + keyHash("old"), keyHash("secret")}},
+					{Resource: "both", Hashes: []string{keyHash("secret")}, Mode: APIKeyCombine},
+				},
+			},
+		},
+	})
+	e := gin.New()
+	e.Use(a.APIKeyAuth(), a.IPFilter())
+	e.GET("/q", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	cases := []struct {
+		remote, url, header string
+		want                int
+	}{
+		{"192.0.2.1:1", "/q?cid=ipcid", "", http.StatusOK},
+		{"198.51.100.1:1", "/q?cid=ipcid", "", http.StatusForbidden},
+		{"198.51.100.1:1", "/q?cid=nat", "secret", http.StatusOK},
+		{"198.51.100.1:1", "/q?cid=nat&apiKey=old", "", http.StatusOK},
+		{"198.51.100.1:1", "/q?cid=nat", "", http.StatusUnauthorized},
+		{"198.51.100.1:1", "/q?cid=nat", "wrong", http.StatusUnauthorized},
+		{"192.0.2.1:1", "/q?cid=both", "secret", http.StatusOK},
+		{"198.51.100.1:1", "/q?cid=both", "secret", http.StatusForbidden},
+		{"192.0.2.1:1", "/q?cid=both", "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.url, nil)
+		req.RemoteAddr = c.remote
+		if len(c.header) > 0 {
+			req.Header.Set("X-API-Key", c.header)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s %s key %q got %d, want %d", c.remote, c.url, c.header, w.Code, c.want)
+		}
+	}
+}
+
+func TestAPIKeyWithoutMiddleware(t *testing.T) {
+	f := New(FilterOptions{APIKeys: APIKeyOptions{Keys: []APIKey{{Resource: "nat", Hashes: []string{keyHash("secret")}}}}})
+	if d := f.decide(nil, &filterRequest{ip: "198.51.100.1", cid: "nat", now: time.Now()}, ""); d.Allowed {
+		t.Errorf("resource should fall back to ip authorization without verified api key")
+	}
+}
+
+func TestAPIKeyFailuresBanned(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		IPFilterRules: FilterOptions{
+			URLPath:  "/q",
+			URLParam: "cid",
+			APIKeys:  APIKeyOptions{Keys: []APIKey{{Resource: "nat", Hashes: []string{keyHash("secret")}}}},
+			Bans: BanOptions{Rules: []BanRule{
+				{Name: "bad-key", Statuses: []int{http.StatusUnauthorized}, Threshold: 3, Window: time.Minute, Duration: time.Hour},
+			}},
+		},
+	})
+	e := gin.New()
+	e.Use(a.APIKeyAuth(), a.SignatureAuth(), a.IPFilter())
+	e.GET("/q", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	serve := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/q?cid=nat", nil)
+		req.RemoteAddr = "198.51.100.1:1"
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		return w.Code
+	}
+	for i := 0; i < 3; i++ {
+		if got := serve("wrong"); got != http.StatusUnauthorized {
+			t.Fatalf("invalid key got %d, want 401", got)
+		}
+	}
+	if _, ok := a.bans.banned("198.51.100.1", time.Now()); !ok {
+		t.Fatalf("ip should be banned after invalid keys")
+	}
+	if got := serve("secret"); got != http.StatusForbidden {
+		t.Errorf("banned ip with valid key got %d, want 403", got)
+	}
+}
@@ -0,0 +1,175 @@
+package awarent
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	rotatelogs "github.com/lestrrat/go-file-rotatelogs"
+)
+
+//reasons of ip filter decision
+const (
+	ReasonBanned         = "banned"
+	ReasonBlocked        = "blocked"
+	ReasonBlockedDefault = "blocked-default"
+	ReasonBlocklist      = "blocklist"
+	ReasonGeoIP          = "geoip"
+	ReasonUnauthorized   = "unauthorized"
+	ReasonAPIKey         = "api-key"
+	ReasonSignature      = "signature"
+	ReasonPolicy         = "policy"
+	ReasonAllowed        = "allowed"
+	ReasonDefault        = "default"
+	ReasonAuthorized     = "authorized"
+)
+
+//Decision ip filter decision, Reason is one of Reason constants and Rule is the matched entry
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	Rule    string `json:"rule,omitempty"`
+}
+
+//AuditOptions decision audit log. denies are written to File as json lines, allows are sampled by AllowSampleRate(0-1).
+//File is rotated every RotationTime(24h by default) and rotated files older than MaxAge(7d by default) are removed
+type AuditOptions struct {
+	File            string        `yaml:"file"`
+	AllowSampleRate float64       `yaml:"allowSampleRate"`
+	RotationTime    time.Duration `yaml:"rotationTime"`
+	MaxAge          time.Duration `yaml:"maxAge"`
+}
+
+//AuditEvent audit record of ip filter decision
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	IP       string    `json:"ip"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Resource string    `json:"resource,omitempty"`
+	Decision
+}
+
+func newAuditEvent(c *gin.Context, now time.Time, ip, resource string, decision Decision) *AuditEvent {
+	return &AuditEvent{
+		Time:     now,
+		IP:       ip,
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Resource: resource,
+		Decision: decision,
+	}
+}
+
+var (
+	auditWritersMu sync.Mutex
+	auditWriters   = map[string]*auditWriter{}
+)
+
+//auditWriter rotating audit file. writers are shared by rule snapshots with the same file, rotation time
+//and max age, and closed once no snapshot references them
+type auditWriter struct {
+	key  string
+	mu   sync.Mutex
+	w    io.WriteCloser
+	refs int
+}
+
+//openAuditWriter nil returned when no file configured or file can not be opened
+func openAuditWriter(opts AuditOptions) *auditWriter {
+	if len(opts.File) == 0 {
+		return nil
+	}
+	rotation := opts.RotationTime
+	if rotation <= 0 {
+		rotation = 24 * time.Hour
+	}
+	maxAge := opts.MaxAge
+	if maxAge <= 0 {
+		maxAge = 7 * 24 * time.Hour
+	}
+	key := opts.File + "|" + rotation.String() + "|" + maxAge.String()
+	auditWritersMu.Lock()
+	defer auditWritersMu.Unlock()
+	if w, ok := auditWriters[key]; ok {
+		w.refs++
+		return w
+	}
+	rl, err := rotatelogs.New(opts.File+".%Y%m%d%H%M",
+		rotatelogs.WithLinkName(opts.File),
+		rotatelogs.WithRotationTime(rotation),
+		rotatelogs.WithMaxAge(maxAge),
+	)
+	if err != nil {
+		log.Printf("open audit log %s error:%v\n", opts.File, err)
+		return nil
+	}
+	w := &auditWriter{key: key, w: rl, refs: 1}
+	auditWriters[key] = w
+	return w
+}
+
+//release drop reference of writer, the file is closed when no reference left.
+//closing is delayed for records in flight
+func (w *auditWriter) release() {
+	auditWritersMu.Lock()
+	defer auditWritersMu.Unlock()
+	if w.refs--; w.refs > 0 {
+		return
+	}
+	delete(auditWriters, w.key)
+	time.AfterFunc(time.Minute, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.w.Close()
+	})
+}
+
+func (w *auditWriter) write(event *AuditEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(append(line, '\n')); err != nil {
+		log.Printf("write audit log error:%v\n", err)
+	}
+}
+
+//auditLog audit of ip filter decisions
+type auditLog struct {
+	opts   AuditOptions
+	writer *auditWriter
+}
+
+func newAuditLog(opts AuditOptions) *auditLog {
+	return &auditLog{opts: opts, writer: openAuditWriter(opts)}
+}
+
+//close release audit writer of replaced snapshot
+func (l *auditLog) close() {
+	if l != nil && l.writer != nil {
+		l.writer.release()
+	}
+}
+
+//record count decision by reason and write it to audit file, allows are sampled
+func (l *auditLog) record(event *AuditEvent) {
+	decision := "deny"
+	if event.Allowed {
+		decision = "allow"
+	}
+	decisionCount.WithLabelValues(decision, event.Reason).Inc()
+	if l == nil || l.writer == nil {
+		return
+	}
+	if event.Allowed && (l.opts.AllowSampleRate <= 0 || rand.Float64() >= l.opts.AllowSampleRate) {
+		return
+	}
+	l.writer.write(event)
+}
@@ -0,0 +1,89 @@
+package awarent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecide(t *testing.T) {
+	f := New(FilterOptions{
+		AllowedIPs:     []string{"10.0.0.0/8"},
+		BlockedIPs:     []string{"10.1.0.0/16", "192.168.1.1"},
+		BlockByDefault: true,
+		AuthorizedIPs:  []Authorized{{Resource: "cid1", IPS: []string{"10.0.0.0/8"}}},
+	})
+	cases := []struct {
+		ip, param string
+		want      Decision
+	}{
+		{"10.0.0.1", "cid1", Decision{Allowed: true, Reason: ReasonAuthorized, Rule: "resource cid1"}},
+		{"10.1.0.1", "cid1", Decision{Reason: ReasonBlocked, Rule: "10.1.0.0/16"}},
+		{"192.168.1.1", "cid1", Decision{Reason: ReasonBlocked, Rule: "192.168.1.1"}},
+		{"172.16.0.1", "cid1", Decision{Reason: ReasonBlockedDefault, Rule: "blockedDefault"}},
+		{"10.0.0.1", "cid2", Decision{Reason: ReasonUnauthorized, Rule: "resource cid2"}},
+		{"10.0.0.1", "", Decision{Reason: ReasonUnauthorized, Rule: "missing resource"}},
+	}
+	for _, c := range cases {
+		if got := f.decide(nil, &filterRequest{ip: c.ip, cid: c.param, now: time.Now()}, ""); got != c.want {
+			t.Errorf("ip %s param %s got %+v, want %+v", c.ip, c.param, got, c.want)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	l := newAuditLog(AuditOptions{File: file})
+	l.record(&AuditEvent{IP: "1.1.1.1", Path: "/q", Resource: "cid1", Decision: Decision{Reason: ReasonBlocked, Rule: "1.1.1.0/24"}})
+	l.record(&AuditEvent{IP: "2.2.2.2", Path: "/q", Resource: "cid1", Decision: Decision{Allowed: true, Reason: ReasonAuthorized}})
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want only deny recorded", len(lines))
+	}
+	var event AuditEvent
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.IP != "1.1.1.1" || event.Reason != ReasonBlocked || event.Rule != "1.1.1.0/24" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestAuditWriterShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	a := &Awarent{}
+	a.setRule(Rule{IPFilterRules: FilterOptions{Audit: AuditOptions{File: file}}})
+	a.setRule(Rule{IPFilterRules: FilterOptions{Audit: AuditOptions{File: file, RotationTime: time.Hour}}})
+	auditWritersMu.Lock()
+	_, day := auditWriters[file+"|24h0m0s|168h0m0s"]
+	hour, ok := auditWriters[file+"|1h0m0s|168h0m0s"]
+	auditWritersMu.Unlock()
+	if day || !ok || hour.refs != 1 {
+		t.Errorf("writer of replaced rule should be released, rotation time of current rule should apply")
+	}
+	a.setRule(Rule{})
+	auditWritersMu.Lock()
+	_, ok = auditWriters[file+"|1h0m0s|168h0m0s"]
+	auditWritersMu.Unlock()
+	if ok {
+		t.Errorf("writer no longer referenced should be released")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/DigitalUnion/dp_aware_demon/balancer"
@@ -53,6 +55,8 @@ type Config struct {
 	Nacos       Nacos  `yaml:"nacos" toml:"nacos" json:"nacos"`
 	ConfigID    string `yaml:"configId" toml:"configId" json:"configId"`
 	RuleID      string `yaml:"ruleId" toml:"ruleId" json:"ruleId"`
+	//tls server options, build http.Server.TLSConfig with NewTLSConfig
+	TLS TLSOptions `yaml:"tls" toml:"tls" json:"tls"`
 }
 
 // Nacos config
@@ -73,7 +77,13 @@ type Awarent struct {
 	ruleID       string
 	nameClient   naming_client.INamingClient
 	configClient config_client.IConfigClient
-	rule         Rule
+	//current rule snapshot, replaced as a whole under mu and read lock free
+	current     atomic.Value
+	mu          sync.Mutex
+	resolveOnce sync.Once
+	bans        banList
+	signing     signingState
+	limiter     ipLimiter
 }
 
 //FlowControlOption option for flow control  resource for specify resource need to be controled, threshold, means every second passed request by flowcontrol. here means QPS
@@ -82,13 +92,26 @@ type FlowControlOption struct {
 	Threshold     float64 `yaml:"threshold"`
 	QueriesPerDay float64 `yaml:"queriesPerDay"`
 	QueryBlock    bool    `yaml:"queryBlock"`
+	//request body and method limits of resource, override limits of route
+	MaxBodyBytes   int64    `yaml:"maxBodyBytes"`
+	AllowedMethods []string `yaml:"allowedMethods"`
 }
 
 //Rule struct for flowcontrol/ipfilter rule collection.
 type Rule struct {
-	ResourceParam    string              `yaml:"resource-param"`
-	FlowControlRules []FlowControlOption `yaml:"flow-control-rules"`
-	IPFilterRules    FilterOptions       `yaml:"ip-filter-rules"`
+	ResourceParam     string              `yaml:"resource-param"`
+	ResourcePathParam string              `yaml:"resource-path-param"`
+	ResourceExtractor ExtractorOptions    `yaml:"resource-extractor"`
+	FlowControlRules  []FlowControlOption `yaml:"flow-control-rules"`
+	Routes            []RouteRule         `yaml:"routes"`
+	Bypass            BypassOptions       `yaml:"bypass"`
+	IPFilterRules     FilterOptions       `yaml:"ip-filter-rules"`
+	//policy of requests with empty or unknown resource
+	UnknownResource UnknownResourceOptions `yaml:"unknown-resource"`
+	//maintenance mode of resources or the whole service
+	Maintenance MaintenanceOptions `yaml:"maintenance"`
+	//fault injection for testing, disabled by default
+	FaultInjection FaultOptions `yaml:"fault-injection"`
 }
 
 //InitAwarent init awarent module
@@ -163,24 +186,30 @@ func (a *Awarent) loadRule(ruleID string, listenOnChange bool) error {
 		log.Printf("decode rule error:%v\n", err)
 		return err
 	}
-	a.rule = rule
+	if err = a.setRule(rule); err != nil {
+		log.Printf("apply rule error:%v\n", err)
+		return err
+	}
 	log.Printf("load rules: %s\n", rc)
-	a.loadFlowControlRules(rule.FlowControlRules...)
+	a.loadFlowControlRules(rule, 1)
 	if listenOnChange {
 		ruleChangedCallback := func(data string) {
 			log.Printf("ruleID:%s changed", ruleID)
 			yamlDecoder := yaml.NewDecoder(strings.NewReader(data))
 			var rule Rule
 			if err := yamlDecoder.Decode(&rule); err != nil {
-				log.Printf("decode yaml error:%v\n", err)
+				log.Printf("decode yaml error:%v, keep current rules\n", err)
+				return
 			}
 			log.Printf("load rules:%s\n", data)
-			a.rule = rule
+			//swap rule snapshot including ip filter rules
+			if err := a.setRule(rule); err != nil {
+				log.Printf("apply rule error:%v, keep current rules\n", err)
+				return
+			}
 
 			//reload rules
-			a.loadFlowControlRules(rule.FlowControlRules...)
-			//update ip filter rules
-			updateIPFilter(rule.IPFilterRules)
+			a.loadFlowControlRules(rule, 1)
 		}
 		a.ConfigOnChange(ruleID, ruleChangedCallback)
 	}
@@ -241,14 +270,11 @@ func (a *Awarent) Subscribe() error {
 		if len(services) > 0 {
 			actives := float64(len(services))
 			log.Printf("subscribe callback return services:%s \n\n", util.ToJsonString(services))
-			var newFlowControlRules []FlowControlOption
-			for _, fr := range a.rule.FlowControlRules {
-				newFlowRule := fr
-				newFlowRule.Threshold = fr.Threshold / actives
-				newFlowControlRules = append(newFlowControlRules, newFlowRule)
+			if ok, err := a.loadFlowControlRules(a.snapshot().rule, actives); ok {
+				log.Printf("balanced flow control with %v actives\n", actives)
+			} else if err != nil {
+				log.Printf("balance flow control error:%v\n", err)
 			}
-			log.Printf("balanced flow control:%s \n", util.ToJsonString(newFlowControlRules))
-			a.loadFlowControlRules(newFlowControlRules...)
 		}
 	}
 	subParam := &vo.SubscribeParam{
@@ -302,22 +328,34 @@ func (a *Awarent) ConfigOnChange(configID string, callback func(data string)) er
 	return a.configClient.ListenConfig(vo)
 }
 
-//loadFlowControlRules load flow control rules
-func (a *Awarent) loadFlowControlRules(rules ...FlowControlOption) (bool, error) {
+//loadFlowControlRules load global and route flow control rules, thresholds are balanced by active instances
+func (a *Awarent) loadFlowControlRules(rule Rule, actives float64) (bool, error) {
+	if actives < 1 {
+		actives = 1
+	}
 	var sentinelRules []*flow.Rule
-	for _, ruleItem := range rules {
-		sentinelRule := &flow.Rule{
-			Resource:               ruleItem.Resource,
-			Threshold:              ruleItem.Threshold,
-			TokenCalculateStrategy: flow.Direct,
-			ControlBehavior:        flow.Reject,
-			StatIntervalInMs:       1000,
+	for _, ruleItem := range rule.FlowControlRules {
+		sentinelRules = append(sentinelRules, newSentinelRule(ruleItem.Resource, ruleItem.Threshold/actives))
+	}
+	for i := range rule.Routes {
+		route := &rule.Routes[i]
+		for _, ruleItem := range route.FlowControlRules {
+			sentinelRules = append(sentinelRules, newSentinelRule(route.resource(ruleItem.Resource), ruleItem.Threshold/actives))
 		}
-		sentinelRules = append(sentinelRules, sentinelRule)
 	}
 	return flow.LoadRules(sentinelRules)
 }
 
+func newSentinelRule(resource string, threshold float64) *flow.Rule {
+	return &flow.Rule{
+		Resource:               resource,
+		Threshold:              threshold,
+		TokenCalculateStrategy: flow.Direct,
+		ControlBehavior:        flow.Reject,
+		StatIntervalInMs:       1000,
+	}
+}
+
 // Metrics wrappers the standard http.Handler to gin.HandlerFunc
 func (a *Awarent) Metrics() gin.HandlerFunc {
 	searcher, err := metric.NewDefaultMetricSearcher(a.logDir, a.serviceName)
@@ -349,139 +387,76 @@ func (a *Awarent) Metrics() gin.HandlerFunc {
 	}
 }
 
-//IPFilter ip filter with options
+//IPFilter ip filter with options. protected path and resource extraction follow current rule on every request.
+//temporarily banned ips are rejected on all paths, responses are observed for automatic bans
+//by the first of APIKeyAuth, SignatureAuth and IPFilter in the chain.
+//expression policies are evaluated here. decisions are counted by reason and recorded to audit log
 func (a *Awarent) IPFilter() gin.HandlerFunc {
-	opts := a.rule.IPFilterRules
-	ipfilter = New(opts)
-	if ipfilter.urlParam != "" {
-		return defaultIpHandler
-	} else {
-		return customIpHandler
-	}
-}
-
-var defaultIpHandler gin.HandlerFunc = func(c *gin.Context) {
-	if ipfilter.urlPath == c.Request.URL.Path {
-		param := c.Query(ipfilter.urlParam)
-		blocked := false
-		ip := c.ClientIP()
-		if !ipfilter.Allowed(ip) {
-			blocked = true
-		} else if !ipfilter.Authorized(ip, param) {
-			blocked = true
+	return a.withBans(func(c *gin.Context) {
+		s := a.requestSnapshot(c)
+		ip := a.clientIP(c)
+		now := time.Now()
+		p, param, ok := s.ipTarget(c)
+		if !ok {
+			c.Next()
+			return
 		}
-		if blocked {
+		decision := s.filter.decide(p, newFilterRequest(c, ip, param, now), s.filter.grant(c, param))
+		s.audit.record(newAuditEvent(c, now, ip, param, decision))
+		if !decision.Allowed {
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
 		c.Next()
+	})
+}
+
+//pathResource resource from gin route param name, slashes of catch-all param are trimmed.
+//last non-empty path segment is used when name is not configured
+func pathResource(c *gin.Context, name string) string {
+	if len(name) > 0 {
+		return strings.Trim(c.Param(name), "/")
 	}
-	c.Next()
+	segments := strings.Split(strings.Trim(c.Request.URL.Path, "/"), "/")
+	return segments[len(segments)-1]
 }
 
-var customIpHandler gin.HandlerFunc = func(c *gin.Context) {
-	urlPath := c.Request.URL.Path
-	if strings.HasPrefix(urlPath, ipfilter.urlPath) {
-		params := strings.Split(urlPath, "/")
-		var param string
-		if len(params) != 0 {
-			param = params[len(params)-1]
-		}
-		blocked := false
-		ip := c.ClientIP()
-		if !ipfilter.Allowed(ip) {
-			blocked = true
-		} else if !ipfilter.Authorized(ip, param) {
-			blocked = true
-		}
-		if blocked {
-			c.AbortWithStatus(http.StatusForbidden)
-			return
-		}
-		c.Next()
+//protectedPath whether path is urlPath itself or nested under urlPath, empty urlPath protect all paths
+func protectedPath(urlPath, path string) bool {
+	urlPath = strings.TrimSuffix(urlPath, "/")
+	if urlPath == "" || path == urlPath {
+		return true
 	}
-	c.Next()
+	return strings.HasPrefix(path, urlPath+"/")
 }
 
 var ruleId string
 
-//Sentinel awarent gin use middleware
+//Sentinel awarent gin use middleware. protected path and resource extraction follow current rule on every request,
+//requests with empty or unknown resource follow unknown resource policy, method and body size limits are checked before flow control
 func (a *Awarent) Sentinel() gin.HandlerFunc {
 	ruleId = a.ruleID
-	if a.rule.ResourceParam != "" {
-		return a.defaultSentinelMiddleware()
-	} else {
-		return a.customSentinelMiddleware()
-	}
-}
-
-func (a *Awarent) defaultSentinelMiddleware() gin.HandlerFunc {
-	return SentinelMiddleware(
+	handler := SentinelMiddleware(
 		// speicify which url path working with sentinel
 		WithParamExtractor(
 			func(ctx *gin.Context) bool {
-				return ctx.Request.URL.Path != a.rule.IPFilterRules.URLPath
-			}),
-		WithBlockExtractor(
-			func(ctx *gin.Context) bool {
-				cid := ctx.Query(a.rule.ResourceParam)
-				for _, rule := range a.rule.FlowControlRules {
-					if cid == rule.Resource {
-						return rule.QueryBlock
-					}
-				}
-				return false
+				return !a.requestSnapshot(ctx).flowProtected(ctx)
 			}),
-		//endpoint,
-		// customize resource extractor if required
-		// method_path by default
-		WithResourceExtractor(func(ctx *gin.Context) string {
-			return ctx.Query(a.rule.ResourceParam)
-		}),
-		// customize block fallback if required
-		// abort with status 429 by default
-		WithBlockFallback(func(ctx *gin.Context) {
-			ctx.AbortWithStatus(http.StatusTooManyRequests)
-			// ctx.AbortWithStatusJSON(http.StatusTooManyRequests, map[string]interface{}{
-			// 	"err":  "too many request; the quota used up",
-			// 	"code": 10222,
-			// })
-		}),
-	)
-}
-
-func (a *Awarent) customSentinelMiddleware() gin.HandlerFunc {
-	return SentinelMiddleware(
-		// speicify which url path working with sentinel
-		WithParamExtractor(
+		WithBypassExtractor(
 			func(ctx *gin.Context) bool {
-				return !strings.HasPrefix(ctx.Request.URL.Path, a.rule.IPFilterRules.URLPath)
+				return a.requestSnapshot(ctx).bypass.bypass(ctx, a.clientIP(ctx))
 			}),
-
 		WithBlockExtractor(
 			func(ctx *gin.Context) bool {
-				params := strings.Split(ctx.Request.URL.Path, "/")
-				var param string
-				if len(params) != 0 {
-					param = params[len(params)-1]
-				}
-				for _, rule := range a.rule.FlowControlRules {
-					if param == rule.Resource {
-						return rule.QueryBlock
-					}
-				}
-				return false
+				s := a.requestSnapshot(ctx)
+				return s.queryBlock(ctx, s.flowResource(ctx))
 			}),
 		//endpoint,
 		// customize resource extractor if required
 		// method_path by default
 		WithResourceExtractor(func(ctx *gin.Context) string {
-			params := strings.Split(ctx.Request.URL.Path, "/")
-			var param string
-			if len(params) != 0 {
-				param = params[len(params)-1]
-			}
-			return param
+			s := a.requestSnapshot(ctx)
+			return s.resourceName(ctx, s.flowResource(ctx))
 		}),
 		// customize block fallback if required
 		// abort with status 429 by default
@@ -493,4 +468,11 @@ func (a *Awarent) customSentinelMiddleware() gin.HandlerFunc {
 			// })
 		}),
 	)
+	return func(c *gin.Context) {
+		a.clientIP(c)
+		if !a.checkRequestLimits(c) || !a.checkUnknownResource(c) {
+			return
+		}
+		handler(c)
+	}
 }
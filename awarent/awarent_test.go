@@ -0,0 +1,95 @@
+package awarent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPathResource(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		route, target, param, want string
+	}{
+		{"/active/:cid", "/active/bigdata", "cid", "bigdata"},
+		{"/active/:cid/devices", "/active/bigdata/devices", "cid", "bigdata"},
+		{"/files/*cid", "/files/bigdata/", "cid", "bigdata"},
+		{"/active/:cid/", "/active/bigdata/", "", "bigdata"},
+		{"/active/:cid/devices", "/active/bigdata/devices", "", "devices"},
+	}
+	for _, tc := range cases {
+		var got string
+		e := gin.New()
+		e.GET(tc.route, func(c *gin.Context) {
+			got = pathResource(c, tc.param)
+		})
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.target, nil))
+		if got != tc.want {
+			t.Errorf("route %s target %s got %q, want %q", tc.route, tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestProtectedPath(t *testing.T) {
+	cases := []struct {
+		urlPath, path string
+		want          bool
+	}{
+		{"/active", "/active", true},
+		{"/active", "/active/bigdata", true},
+		{"/active/", "/active/bigdata/devices", true},
+		{"/active", "/activex/bigdata", false},
+		{"", "/q", true},
+	}
+	for _, tc := range cases {
+		if got := protectedPath(tc.urlPath, tc.path); got != tc.want {
+			t.Errorf("protectedPath(%q, %q) got %v, want %v", tc.urlPath, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestIPFilterFollowsRuleChange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{IPFilterRules: FilterOptions{
+		URLPath:       "/q",
+		URLParam:      "cid",
+		AuthorizedIPs: []Authorized{{Resource: "bigdata", IPS: []string{"192.0.2.1"}}},
+	}})
+	e := gin.New()
+	e.Use(a.IPFilter())
+	e.GET("/q", func(c *gin.Context) { c.Status(http.StatusOK) })
+	e.GET("/active/:cid", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(target string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := serve("/q?cid=bigdata"); code != http.StatusOK {
+		t.Fatalf("query mode authorized got %d", code)
+	}
+	if code := serve("/active/bigdata"); code != http.StatusOK {
+		t.Fatalf("unprotected path got %d", code)
+	}
+
+	opts := FilterOptions{
+		URLPath:       "/active",
+		URLPathParam:  "cid",
+		AuthorizedIPs: []Authorized{{Resource: "bigdata", IPS: []string{"192.0.2.1"}}},
+	}
+	a.setRule(Rule{IPFilterRules: opts})
+	if code := serve("/active/bigdata"); code != http.StatusOK {
+		t.Fatalf("path mode authorized got %d", code)
+	}
+	if code := serve("/active/ads"); code != http.StatusForbidden {
+		t.Fatalf("path mode unauthorized got %d", code)
+	}
+	if code := serve("/q?cid=ads"); code != http.StatusOK {
+		t.Fatalf("path no longer protected got %d", code)
+	}
+}
@@ -0,0 +1,294 @@
+package awarent
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+//BanOptions automatic temporary ip ban, ip hit any rule is banned for Duration of the rule and rejected by ip filter.
+//Ignore are ip or cidr never banned. banned ips are published to nacos PublishID dataId if configured
+type BanOptions struct {
+	Rules     []BanRule `yaml:"rules"`
+	Ignore    []string  `yaml:"ignore"`
+	PublishID string    `yaml:"publishId"`
+}
+
+//BanRule ban ip when it gets Threshold responses of Statuses within Window,
+//or tries more than DistinctResources different resources(cid) within Window
+type BanRule struct {
+	Name              string        `yaml:"name"`
+	Statuses          []int         `yaml:"statuses"`
+	Threshold         int           `yaml:"threshold"`
+	DistinctResources int           `yaml:"distinctResources"`
+	Window            time.Duration `yaml:"window"`
+	Duration          time.Duration `yaml:"duration"`
+}
+
+//Ban banned ip
+type Ban struct {
+	IP      string    `yaml:"ip" json:"ip"`
+	Rule    string    `yaml:"rule" json:"rule"`
+	Since   time.Time `yaml:"since" json:"since"`
+	Expires time.Time `yaml:"expires" json:"expires"`
+}
+
+//banList banned ips and behavior counters, the zero value is ready to use.
+//state is kept across rule reloads
+type banList struct {
+	mu        sync.Mutex
+	bans      map[string]Ban
+	events    map[string][]time.Time
+	resources map[string]map[string]time.Time
+	swept     time.Time
+}
+
+const (
+	banSweepInterval = time.Minute
+	banGuardKey      = "awarent.banGuard"
+)
+
+func (b *banList) init() {
+	if b.bans == nil {
+		b.bans = map[string]Ban{}
+		b.events = map[string][]time.Time{}
+		b.resources = map[string]map[string]time.Time{}
+	}
+}
+
+//banned whether ip is banned now, expired ban is removed
+func (b *banList) banned(ip string, now time.Time) (Ban, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ban, ok := b.bans[ip]
+	if !ok {
+		return Ban{}, false
+	}
+	if now.Before(ban.Expires) {
+		return ban, true
+	}
+	delete(b.bans, ip)
+	bannedIPs.Set(float64(len(b.bans)))
+	return Ban{}, false
+}
+
+//observe record response of ip and ban it if any rule hit, true returned when ip is newly banned
+func (b *banList) observe(opts *BanOptions, ignore *ipTrie, ip, resource string, status int, now time.Time) bool {
+	if len(opts.Rules) == 0 || len(ip) == 0 {
+		return false
+	}
+	if parsed := net.ParseIP(ip); parsed == nil || ignore.contains(parsed) {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.sweep(opts, now)
+	if _, ok := b.bans[ip]; ok {
+		return false
+	}
+	for i := range opts.Rules {
+		rule := &opts.Rules[i]
+		key := rule.Name + "|" + ip
+		hit := false
+		if rule.DistinctResources > 0 && len(resource) > 0 {
+			seen := b.resources[key]
+			if seen == nil {
+				seen = map[string]time.Time{}
+				b.resources[key] = seen
+			}
+			seen[resource] = now
+			for res, at := range seen {
+				if now.Sub(at) > rule.Window {
+					delete(seen, res)
+				}
+			}
+			hit = len(seen) > rule.DistinctResources
+		}
+		if rule.Threshold > 0 && rule.matchStatus(status) {
+			events := append(pruneEvents(b.events[key], now, rule.Window), now)
+			b.events[key] = events
+			hit = hit || len(events) >= rule.Threshold
+		}
+		if hit {
+			b.bans[ip] = Ban{IP: ip, Rule: rule.Name, Since: now, Expires: now.Add(rule.Duration)}
+			delete(b.events, key)
+			delete(b.resources, key)
+			banCount.WithLabelValues(rule.Name).Inc()
+			bannedIPs.Set(float64(len(b.bans)))
+			log.Printf("ip:%s banned by rule:%s until %s\n", ip, rule.Name, now.Add(rule.Duration).Format(time.RFC3339))
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *BanRule) matchStatus(status int) bool {
+	for _, s := range rule.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func pruneEvents(events []time.Time, now time.Time, window time.Duration) []time.Time {
+	idx := 0
+	for idx < len(events) && now.Sub(events[idx]) > window {
+		idx++
+	}
+	return events[idx:]
+}
+
+//sweep drop expired bans and idle counters, it runs at most once per banSweepInterval
+func (b *banList) sweep(opts *BanOptions, now time.Time) {
+	if now.Sub(b.swept) < banSweepInterval {
+		return
+	}
+	b.swept = now
+	var window time.Duration
+	for _, rule := range opts.Rules {
+		if rule.Window > window {
+			window = rule.Window
+		}
+	}
+	for ip, ban := range b.bans {
+		if !now.Before(ban.Expires) {
+			delete(b.bans, ip)
+		}
+	}
+	for key, events := range b.events {
+		if len(pruneEvents(events, now, window)) == 0 {
+			delete(b.events, key)
+		}
+	}
+	for key, seen := range b.resources {
+		for res, at := range seen {
+			if now.Sub(at) > window {
+				delete(seen, res)
+			}
+		}
+		if len(seen) == 0 {
+			delete(b.resources, key)
+		}
+	}
+	bannedIPs.Set(float64(len(b.bans)))
+}
+
+//list active bans ordered by expiration
+func (b *banList) list(now time.Time) []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bans := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		if now.Before(ban.Expires) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Expires.Before(bans[j].Expires) })
+	return bans
+}
+
+//remove unban ip, false returned if ip is not banned
+func (b *banList) remove(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.bans[ip]; !ok {
+		return false
+	}
+	delete(b.bans, ip)
+	bannedIPs.Set(float64(len(b.bans)))
+	return true
+}
+
+//newBanIgnore ignore list of bans
+func newBanIgnore(opts BanOptions) *ipTrie {
+	ignore := newIPTrie()
+	for _, entry := range opts.Ignore {
+		if !ignore.insertEntry(entry, true, true) {
+			log.Printf("ban ignore entry ignored:%q is not ip or cidr\n", entry)
+		}
+	}
+	return ignore
+}
+
+//withBans guard handler with automatic bans. the first awarent middleware of request rejects banned ip
+//and observes the final response status, so that requests rejected by any later middleware count for bans
+func (a *Awarent) withBans(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool(banGuardKey) {
+			handler(c)
+			return
+		}
+		c.Set(banGuardKey, true)
+		s := a.requestSnapshot(c)
+		ip := a.clientIP(c)
+		now := time.Now()
+		if ban, ok := a.bans.banned(ip, now); ok {
+			s.audit.record(newAuditEvent(c, now, ip, "", Decision{Reason: ReasonBanned, Rule: ban.Rule}))
+			c.Header("Retry-After", ban.retryAfter(now))
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		defer func() {
+			_, param, _ := s.ipTarget(c)
+			a.observeBan(s, ip, param, c.Writer.Status())
+		}()
+		handler(c)
+	}
+}
+
+//observeBan record response for bans, newly banned ips are published to nacos if configured
+func (a *Awarent) observeBan(s *ruleSnapshot, ip, resource string, status int) {
+	opts := &s.rule.IPFilterRules.Bans
+	if a.bans.observe(opts, s.banIgnore, ip, resource, status, time.Now()) && len(opts.PublishID) > 0 {
+		go a.publishBans(opts.PublishID)
+	}
+}
+
+//publishBans publish active bans to nacos dataId as yaml
+func (a *Awarent) publishBans(dataID string) {
+	if a.configClient == nil {
+		return
+	}
+	content, err := yaml.Marshal(a.bans.list(time.Now()))
+	if err != nil {
+		log.Printf("marshal bans error:%v\n", err)
+		return
+	}
+	if _, err := a.PublishConfig(dataID, string(content)); err != nil {
+		log.Printf("publish bans error:%v\n", err)
+	}
+}
+
+//BanHandler admin handler of automatic bans. GET lists active bans, DELETE with ip query unbans the ip
+func (a *Awarent) BanHandler(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet:
+		c.JSON(http.StatusOK, a.bans.list(time.Now()))
+	case http.MethodDelete:
+		ip := normalizeIP(c.Query("ip"))
+		if !a.bans.remove(ip) {
+			c.String(http.StatusNotFound, "ip %s not banned", c.Query("ip"))
+			return
+		}
+		if publishID := a.snapshot().rule.IPFilterRules.Bans.PublishID; len(publishID) > 0 {
+			go a.publishBans(publishID)
+		}
+		c.String(http.StatusOK, "ip %s unbanned", ip)
+	default:
+		c.AbortWithStatus(http.StatusMethodNotAllowed)
+	}
+}
+
+//retryAfter seconds until ban expires
+func (ban Ban) retryAfter(now time.Time) string {
+	return strconv.Itoa(int(ban.Expires.Sub(now).Seconds()) + 1)
+}
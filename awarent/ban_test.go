@@ -0,0 +1,61 @@
+package awarent
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBanListStatuses(t *testing.T) {
+	opts := &BanOptions{
+		Rules:  []BanRule{{Name: "forbidden", Statuses: []int{http.StatusForbidden}, Threshold: 3, Window: 10 * time.Second, Duration: time.Minute}},
+		Ignore: []string{"10.0.0.0/8"},
+	}
+	ignore := newBanIgnore(*opts)
+	var b banList
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if b.observe(opts, ignore, "192.0.2.1", "", http.StatusForbidden, now.Add(time.Duration(i*5)*time.Second)) {
+			t.Fatalf("banned before threshold")
+		}
+	}
+	b.observe(opts, ignore, "192.0.2.1", "", http.StatusOK, now.Add(2*time.Second))
+	//first event slides out of window
+	if b.observe(opts, ignore, "192.0.2.1", "", http.StatusForbidden, now.Add(11*time.Second)) {
+		t.Fatalf("events out of window should not ban")
+	}
+	if !b.observe(opts, ignore, "192.0.2.1", "", http.StatusForbidden, now.Add(12*time.Second)) {
+		t.Fatalf("expect banned after threshold within window")
+	}
+	if _, ok := b.banned("192.0.2.1", now.Add(13*time.Second)); !ok {
+		t.Errorf("expect ip banned")
+	}
+	if _, ok := b.banned("192.0.2.1", now.Add(13*time.Second+time.Minute)); ok {
+		t.Errorf("ban should expire")
+	}
+	for i := 0; i < 5; i++ {
+		if b.observe(opts, ignore, "10.1.1.1", "", http.StatusForbidden, now) {
+			t.Fatalf("ignored ip should never be banned")
+		}
+	}
+}
+
+func TestBanListDistinctResources(t *testing.T) {
+	opts := &BanOptions{
+		Rules: []BanRule{{Name: "scan", DistinctResources: 2, Window: time.Minute, Duration: time.Hour}},
+	}
+	var b banList
+	now := time.Now()
+	b.observe(opts, nil, "192.0.2.1", "a", http.StatusOK, now)
+	b.observe(opts, nil, "192.0.2.1", "a", http.StatusOK, now)
+	b.observe(opts, nil, "192.0.2.1", "b", http.StatusOK, now)
+	if !b.observe(opts, nil, "192.0.2.1", "c", http.StatusOK, now) {
+		t.Fatalf("expect banned after trying 3 distinct resources")
+	}
+	if bans := b.list(now); len(bans) != 1 || bans[0].Rule != "scan" {
+		t.Errorf("unexpected bans %v", bans)
+	}
+	if !b.remove("192.0.2.1") || len(b.list(now)) != 0 {
+		t.Errorf("expect ip unbanned")
+	}
+}
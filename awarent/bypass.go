@@ -0,0 +1,83 @@
+package awarent
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultBypassHeader = "X-Bypass-Token"
+
+//BypassOptions trusted requests skipping flow control and quota accounting, such as health checks and internal jobs.
+//request bypasses when client ip is in IPs(ip or cidr) or Header(X-Bypass-Token by default) carries a token
+//whose hex sha256 is in Tokens
+type BypassOptions struct {
+	IPs    []string `yaml:"ips"`
+	Header string   `yaml:"header"`
+	Tokens []string `yaml:"tokens"`
+}
+
+type bypassFilter struct {
+	ips    *ipTrie
+	header string
+	tokens [][]byte
+}
+
+//newBypassFilter nil returned when nothing configured
+func newBypassFilter(opts BypassOptions) *bypassFilter {
+	if len(opts.IPs) == 0 && len(opts.Tokens) == 0 {
+		return nil
+	}
+	b := &bypassFilter{ips: newIPTrie(), header: opts.Header}
+	if len(b.header) == 0 {
+		b.header = defaultBypassHeader
+	}
+	for _, entry := range opts.IPs {
+		if !b.ips.insertEntry(entry, true, true) {
+			log.Printf("bypass entry ignored:%q is not ip or cidr\n", entry)
+		}
+	}
+	for _, token := range opts.Tokens {
+		sum, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(token), "sha256:"))
+		if err != nil || len(sum) != sha256.Size {
+			log.Printf("bypass token ignored: not hex sha256\n")
+			continue
+		}
+		b.tokens = append(b.tokens, sum)
+	}
+	return b
+}
+
+//bypass whether request from ip bypasses flow control
+func (b *bypassFilter) bypass(c *gin.Context, ip string) bool {
+	if b == nil {
+		return false
+	}
+	if b.bypassIP(ip) {
+		return true
+	}
+	token := c.GetHeader(b.header)
+	if len(token) == 0 || len(b.tokens) == 0 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	matched := 0
+	for _, hash := range b.tokens {
+		matched |= subtle.ConstantTimeCompare(sum[:], hash)
+	}
+	return matched == 1
+}
+
+//bypassIP whether ip is in bypass networks
+func (b *bypassFilter) bypassIP(ip string) bool {
+	if b == nil {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	return parsed != nil && b.ips.contains(parsed)
+}
@@ -0,0 +1,47 @@
+package awarent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBypass(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam:    "cid",
+		FlowControlRules: []FlowControlOption{{Resource: "suspended", Threshold: 10, QueryBlock: true}},
+		Bypass: BypassOptions{
+			IPs:    []string{"10.0.0.0/8"},
+			Tokens: []string{keyHash("reconcile")},
+		},
+		IPFilterRules: FilterOptions{URLPath: "/q", URLParam: "cid"},
+	})
+	e := gin.New()
+	e.Use(a.Sentinel())
+	e.GET("/q", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	cases := []struct {
+		remote, token string
+		want          int
+	}{
+		{"10.1.2.3:1", "", http.StatusOK},
+		{"198.51.100.1:1", "reconcile", http.StatusOK},
+		{"198.51.100.1:1", "wrong", http.StatusTooManyRequests},
+		{"198.51.100.1:1", "", http.StatusTooManyRequests},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/q?cid=suspended", nil)
+		req.RemoteAddr = c.remote
+		if len(c.token) > 0 {
+			req.Header.Set("X-Bypass-Token", c.token)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s token %q got %d, want %d", c.remote, c.token, w.Code, c.want)
+		}
+	}
+}
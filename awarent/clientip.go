@@ -0,0 +1,121 @@
+package awarent
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	//HeaderProxyProtocol pseudo header of clientIPHeaders, source address of PROXY protocol
+	HeaderProxyProtocol = "proxy-protocol"
+
+	clientIPKey = "awarent.clientIP"
+)
+
+//defaultClientIPHeaders header precedence when trusted proxies configured without clientIPHeaders
+var defaultClientIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+//clientIPResolver resolve client ip of request. headers are only trusted when the peer is a trusted proxy,
+//X-Forwarded-For is walked from right to left skipping trusted proxies
+type clientIPResolver struct {
+	trusted *ipTrie
+	headers []string
+}
+
+func newClientIPResolver(opts FilterOptions) *clientIPResolver {
+	r := &clientIPResolver{trusted: newIPTrie()}
+	for _, proxy := range opts.TrustedProxies {
+		r.trusted.insertEntry(proxy, true, true)
+	}
+	headers := opts.ClientIPHeaders
+	if len(headers) == 0 {
+		headers = defaultClientIPHeaders
+	}
+	for _, header := range headers {
+		if strings.EqualFold(header, HeaderProxyProtocol) {
+			r.headers = append(r.headers, HeaderProxyProtocol)
+		} else {
+			r.headers = append(r.headers, http.CanonicalHeaderKey(header))
+		}
+	}
+	return r
+}
+
+func (r *clientIPResolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && r.trusted.contains(parsed)
+}
+
+//resolve client ip of request, empty if remote address is invalid
+func (r *clientIPResolver) resolve(req *http.Request) string {
+	peer := remoteIP(req)
+	if r == nil || !r.isTrusted(peer) {
+		return peer
+	}
+	for _, header := range r.headers {
+		var ip string
+		switch header {
+		case HeaderProxyProtocol:
+			if source := proxyProtoSource(req.Context()); source != nil {
+				ip = normalizeIP(source.String())
+			}
+		case "X-Forwarded-For":
+			ip = r.forwardedFor(req.Header.Values(header))
+		default:
+			ip = normalizeIP(req.Header.Get(header))
+		}
+		if len(ip) > 0 {
+			return ip
+		}
+	}
+	return peer
+}
+
+//forwardedFor first untrusted ip from right of X-Forwarded-For, leftmost ip if all trusted
+func (r *clientIPResolver) forwardedFor(values []string) string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, normalizeIP(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if len(hops[i]) == 0 {
+			return ""
+		}
+		if !r.isTrusted(hops[i]) || i == 0 {
+			return hops[i]
+		}
+	}
+	return ""
+}
+
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr))
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return normalizeIP(host)
+}
+
+//ClientIP client ip resolved by awarent middlewares with trusted proxies of ip filter rules,
+//fallback to gin ClientIP when request is not passed through awarent middlewares
+func ClientIP(c *gin.Context) string {
+	if ip := c.GetString(clientIPKey); len(ip) > 0 {
+		return ip
+	}
+	return c.ClientIP()
+}
+
+//clientIP resolve client ip with rule snapshot of request, resolved ip is shared by middlewares
+func (a *Awarent) clientIP(c *gin.Context) string {
+	if ip := c.GetString(clientIPKey); len(ip) > 0 {
+		return ip
+	}
+	ip := a.requestSnapshot(c).clientIP.resolve(c.Request)
+	c.Set(clientIPKey, ip)
+	return ip
+}
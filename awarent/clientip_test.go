@@ -0,0 +1,97 @@
+package awarent
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	untrusted := newClientIPResolver(FilterOptions{})
+	trusted := newClientIPResolver(FilterOptions{TrustedProxies: []string{"10.0.0.0/8"}})
+	realIP := newClientIPResolver(FilterOptions{
+		TrustedProxies:  []string{"10.0.0.1"},
+		ClientIPHeaders: []string{"x-real-ip", "X-Forwarded-For"},
+	})
+	cases := []struct {
+		name     string
+		resolver *clientIPResolver
+		remote   string
+		header   http.Header
+		want     string
+	}{
+		{"spoofed header ignored without trusted proxies", untrusted, "192.0.2.1:1234",
+			http.Header{"X-Forwarded-For": {"127.0.0.1"}}, "192.0.2.1"},
+		{"untrusted peer header ignored", trusted, "192.0.2.1:1234",
+			http.Header{"X-Forwarded-For": {"127.0.0.1"}}, "192.0.2.1"},
+		{"forwarded for skip trusted hops", trusted, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"127.0.0.1, 198.51.100.7", "10.0.0.2"}}, "198.51.100.7"},
+		{"forwarded for all trusted", trusted, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"header precedence", realIP, "10.0.0.1:1234",
+			http.Header{"X-Real-Ip": {"198.51.100.8"}, "X-Forwarded-For": {"198.51.100.9"}}, "198.51.100.8"},
+		{"invalid header fallback", realIP, "10.0.0.1:1234",
+			http.Header{"X-Real-Ip": {"garbage"}}, "10.0.0.1"},
+		{"ipv4 mapped remote", untrusted, "[::ffff:192.0.2.1]:1234", nil, "192.0.2.1"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		req.Header = tc.header
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		if got := tc.resolver.resolve(req); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	headers := map[string][]byte{
+		"v1": []byte("PROXY TCP4 198.51.100.7 10.0.0.1 56324 8080\r\n"),
+		"v2": append([]byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"),
+			198, 51, 100, 7, 10, 0, 0, 1, 0xdb, 0xe4, 0x1f, 0x90),
+	}
+	resolver := newClientIPResolver(FilterOptions{
+		TrustedProxies:  []string{"127.0.0.1"},
+		ClientIPHeaders: []string{"proxy-protocol"},
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewProxyProtoListener(ln)
+	defer l.Close()
+	for name, header := range headers {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write(append(header, []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")...))
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			t.Fatalf("%s: read request error:%v", name, err)
+		}
+		req = req.WithContext(ConnContext(context.Background(), conn))
+		req.RemoteAddr = "127.0.0.1:40000"
+		if got := resolver.resolve(req); got != "198.51.100.7" {
+			t.Errorf("%s: got %q", name, got)
+		}
+		//read deadline of server is kept after the header is read
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Errorf("%s: stalled read should time out", name)
+		}
+		conn.Close()
+		client.Close()
+	}
+}
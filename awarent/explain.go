@@ -0,0 +1,294 @@
+package awarent
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alibaba/sentinel-golang/core/flow"
+	"github.com/gin-gonic/gin"
+)
+
+//ExplainStep single check of decision trace
+type ExplainStep struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+//Explanation evaluation trace of a request through maintenance mode, ip filter and flow control against current rule snapshot
+type Explanation struct {
+	IP          string        `json:"ip"`
+	Method      string        `json:"method"`
+	Path        string        `json:"path"`
+	Route       string        `json:"route,omitempty"`
+	Resource    string        `json:"resource"`
+	APIKey      bool          `json:"apiKey"`
+	Subject     string        `json:"subject,omitempty"`
+	SAN         string        `json:"san,omitempty"`
+	Header      http.Header   `json:"header,omitempty"`
+	Allowed     bool          `json:"allowed"`
+	Decision    Decision      `json:"decision"`
+	Maintenance []ExplainStep `json:"maintenance"`
+	IPFilter    []ExplainStep `json:"ipFilter"`
+	FlowControl []ExplainStep `json:"flowControl"`
+}
+
+//ExplainHandler admin handler explaining whether ip calling resource on path would be allowed now and why.
+//query params are ip, resource, path, method(GET by default) and route, the gin route pattern of path which defaults to path.
+//apiKey=true assumes request carries valid api key of resource, subject and san are identities of verified client certificate.
+//header is Name:Value request header evaluated by policies and can be repeated.
+//at is RFC3339 time to evaluate schedules of entries and policies, now by default
+func (a *Awarent) ExplainHandler(c *gin.Context) {
+	ip := normalizeIP(c.Query("ip"))
+	if len(ip) == 0 {
+		c.String(http.StatusBadRequest, "invalid ip %q", c.Query("ip"))
+		return
+	}
+	path := c.DefaultQuery("path", "/")
+	method := strings.ToUpper(c.DefaultQuery("method", http.MethodGet))
+	route := c.DefaultQuery("route", path)
+	e := &Explanation{
+		IP:       ip,
+		Method:   method,
+		Path:     path,
+		Resource: c.Query("resource"),
+		APIKey:   c.Query("apiKey") == "true",
+		Subject:  c.Query("subject"),
+		SAN:      c.Query("san"),
+	}
+	for _, header := range c.QueryArray("header") {
+		idx := strings.IndexByte(header, ':')
+		if idx <= 0 {
+			c.String(http.StatusBadRequest, "invalid header %q", header)
+			return
+		}
+		if e.Header == nil {
+			e.Header = http.Header{}
+		}
+		e.Header.Add(strings.TrimSpace(header[:idx]), strings.TrimSpace(header[idx+1:]))
+	}
+	now := time.Now()
+	if at := c.Query("at"); len(at) > 0 {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid time %q", at)
+			return
+		}
+		now = t
+	}
+	c.JSON(http.StatusOK, a.explain(a.snapshot(), e, route, now))
+}
+
+func (a *Awarent) explain(s *ruleSnapshot, e *Explanation, route string, now time.Time) *Explanation {
+	maintenanceAllowed := explainMaintenance(s, e, route)
+	e.Decision = a.explainIPFilter(s, e, now)
+	flowAllowed := explainFlowControl(s, e, route)
+	e.Allowed = maintenanceAllowed && e.Decision.Allowed && flowAllowed
+	return e
+}
+
+//explainMaintenance trace of maintenance mode, false returned if request is rejected with 503
+func explainMaintenance(s *ruleSnapshot, e *Explanation, fullPath string) bool {
+	m := s.maintenance
+	if m == nil {
+		e.Maintenance = append(e.Maintenance, ExplainStep{Check: "scope", Passed: true, Detail: "maintenance disabled"})
+		return true
+	}
+	_, route := s.rule.findRoute(e.Method, fullPath)
+	_, path := s.filter.matchPath(e.Path)
+	switch {
+	case !route && !s.flowPathProtected(e.Path) && !path && !s.ipProtected(e.Path):
+		e.Maintenance = append(e.Maintenance, ExplainStep{Check: "scope", Passed: true, Detail: "path not protected by flow control or ip filter"})
+		return true
+	case m.resources == nil:
+		e.Maintenance = append(e.Maintenance, ExplainStep{Check: "scope", Detail: "whole service in maintenance"})
+	case m.resources[e.Resource]:
+		e.Maintenance = append(e.Maintenance, ExplainStep{Check: "scope", Detail: fmt.Sprintf("resource %q in maintenance", e.Resource)})
+	default:
+		e.Maintenance = append(e.Maintenance, ExplainStep{Check: "scope", Passed: true, Detail: fmt.Sprintf("resource %q not in maintenance", e.Resource)})
+		return true
+	}
+	if ip := net.ParseIP(e.IP); ip != nil && m.allowed.contains(ip) {
+		e.Maintenance = append(e.Maintenance, ExplainStep{Check: "allowedIPs", Passed: true, Detail: "ip allowed during maintenance"})
+		return true
+	}
+	e.Maintenance = append(e.Maintenance, ExplainStep{Check: "allowedIPs", Detail: "ip not allowed during maintenance, rejected with 503"})
+	return false
+}
+
+//explainIPFilter trace of ip filter, the final ip filter decision is returned
+func (a *Awarent) explainIPFilter(s *ruleSnapshot, e *Explanation, now time.Time) Decision {
+	if ban, ok := a.bans.banned(e.IP, now); ok {
+		e.IPFilter = append(e.IPFilter, ExplainStep{Check: "ban", Detail: fmt.Sprintf("banned by rule %s until %s", ban.Rule, ban.Expires.Format(time.RFC3339))})
+		return Decision{Reason: ReasonBanned, Rule: ban.Rule}
+	}
+	e.IPFilter = append(e.IPFilter, ExplainStep{Check: "ban", Passed: true, Detail: "not banned"})
+	f := s.filter
+	p, ok := f.matchPath(e.Path)
+	switch {
+	case ok:
+		e.IPFilter = append(e.IPFilter, ExplainStep{Check: "protected", Passed: true, Detail: "path matched " + p.pattern})
+	case s.ipProtected(e.Path):
+		e.IPFilter = append(e.IPFilter, ExplainStep{Check: "protected", Passed: true, Detail: fmt.Sprintf("path under urlPath %q", s.rule.IPFilterRules.URLPath)})
+	default:
+		e.IPFilter = append(e.IPFilter, ExplainStep{Check: "protected", Passed: true, Detail: "path not protected by ip filter"})
+		return Decision{Allowed: true, Reason: ReasonDefault, Rule: "unprotected path"}
+	}
+	access := f.access(e.IP, now)
+	e.IPFilter = append(e.IPFilter, ExplainStep{Check: "access", Passed: access.Allowed, Detail: explainDecision(access)})
+	if f.geo != nil {
+		geoOK, reason := f.geoAllowed(e.IP, now)
+		if geoOK {
+			reason = "geo allowed"
+		}
+		e.IPFilter = append(e.IPFilter, ExplainStep{Check: "geoip", Passed: geoOK, Detail: reason})
+	}
+	r := &filterRequest{ip: e.IP, cid: e.Resource, path: e.Path, method: e.Method, header: e.Header, now: now}
+	if len(f.policies) > 0 {
+		if policy, ok := f.policies.match(r); ok {
+			e.IPFilter = append(e.IPFilter, ExplainStep{Check: "policy", Passed: policy.allow, Detail: explainDecision(policy.decision())})
+		} else {
+			e.IPFilter = append(e.IPFilter, ExplainStep{Check: "policy", Passed: true, Detail: "no policy matched"})
+		}
+	}
+	if entry, ok := f.apiKeys[e.Resource]; ok {
+		mode := APIKeyReplace
+		if entry.combine {
+			mode = APIKeyCombine
+		}
+		e.IPFilter = append(e.IPFilter, ExplainStep{Check: "apiKey", Passed: e.APIKey, Detail: "api key required in " + mode + " mode"})
+		if !e.APIKey {
+			return Decision{Reason: ReasonAPIKey, Rule: "missing api key"}
+		}
+	}
+	var grant string
+	if e.APIKey && f.apiKeys.replaces(e.Resource) {
+		grant = "api key of resource " + e.Resource
+		e.IPFilter = append(e.IPFilter, ExplainStep{Check: "grant", Passed: true, Detail: grant})
+	}
+	if _, ok := f.certs[e.Resource]; ok && len(grant) == 0 {
+		if id := f.certs.authorized(e.certificate(), e.Resource); len(id) > 0 {
+			grant = "client certificate " + id
+			e.IPFilter = append(e.IPFilter, ExplainStep{Check: "grant", Passed: true, Detail: grant})
+		} else {
+			e.IPFilter = append(e.IPFilter, ExplainStep{Check: "grant", Detail: "client certificate not authorized"})
+		}
+	}
+	table := "global authorized"
+	if p != nil && p.authorizedIPs != nil {
+		table = "authorized of path " + p.pattern
+	}
+	authorized := f.authorizedPath(p, e.IP, e.Resource, now)
+	e.IPFilter = append(e.IPFilter, ExplainStep{Check: "authorized", Passed: authorized, Detail: fmt.Sprintf("resource %q in %s", e.Resource, table)})
+	return f.decide(p, r, grant)
+}
+
+//certificate client certificate with subject common name and san of explanation
+func (e *Explanation) certificate() *x509.Certificate {
+	if len(e.Subject) == 0 && len(e.SAN) == 0 {
+		return nil
+	}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: e.Subject}}
+	switch {
+	case len(e.SAN) == 0:
+	case net.ParseIP(e.SAN) != nil:
+		cert.IPAddresses = []net.IP{net.ParseIP(e.SAN)}
+	case strings.Contains(e.SAN, "://"):
+		if uri, err := url.Parse(e.SAN); err == nil {
+			cert.URIs = []*url.URL{uri}
+		}
+	case strings.Contains(e.SAN, "@"):
+		cert.EmailAddresses = []string{e.SAN}
+	default:
+		cert.DNSNames = []string{e.SAN}
+	}
+	return cert
+}
+
+//explainFlowControl trace of flow control, false returned if method is not allowed, resource is suspended or rejected as unknown resource
+func explainFlowControl(s *ruleSnapshot, e *Explanation, fullPath string) bool {
+	route, ok := s.rule.findRoute(e.Method, fullPath)
+	switch {
+	case ok:
+		e.Route = routeKey(route.Method, route.Path)
+		e.FlowControl = append(e.FlowControl, ExplainStep{Check: "protected", Passed: true, Detail: "route rule " + e.Route})
+	case s.flowPathProtected(e.Path):
+		e.FlowControl = append(e.FlowControl, ExplainStep{Check: "protected", Passed: true, Detail: fmt.Sprintf("path under urlPath %q", s.rule.IPFilterRules.URLPath)})
+	default:
+		e.FlowControl = append(e.FlowControl, ExplainStep{Check: "protected", Passed: true, Detail: "path not under flow control"})
+		return true
+	}
+	if maxBody, methods := s.routeLimits(route, e.Resource); maxBody > 0 || len(methods) > 0 {
+		if !methodAllowed(methods, e.Method) {
+			e.FlowControl = append(e.FlowControl, ExplainStep{Check: "limits", Detail: fmt.Sprintf("method %s not in %v, rejected with 405", e.Method, methods)})
+			return false
+		}
+		detail := "any method"
+		if len(methods) > 0 {
+			detail = fmt.Sprintf("method in %v", methods)
+		}
+		if maxBody > 0 {
+			detail += fmt.Sprintf(", body up to %d bytes", maxBody)
+		}
+		e.FlowControl = append(e.FlowControl, ExplainStep{Check: "limits", Passed: true, Detail: detail})
+	}
+	if s.bypass.bypassIP(e.IP) {
+		e.FlowControl = append(e.FlowControl, ExplainStep{Check: "bypass", Passed: true, Detail: "ip bypasses flow control"})
+		return true
+	}
+	opt, ok := s.rule.option(route, e.Resource)
+	if !ok || len(e.Resource) == 0 {
+		e.FlowControl = append(e.FlowControl, ExplainStep{Check: "rule", Passed: true, Detail: fmt.Sprintf("no flow control rule of resource %q", e.Resource)})
+		return explainUnknownResource(s, e)
+	}
+	name := e.Resource
+	if scope := s.rule.scope(route, e.Resource); scope != nil {
+		name = scope.resource(e.Resource)
+	}
+	threshold := fmt.Sprintf("configured %v per second for resource %s", opt.Threshold, name)
+	for _, r := range flow.GetRulesOfResource(name) {
+		threshold += fmt.Sprintf(", %v per second on this instance", r.Threshold)
+	}
+	e.FlowControl = append(e.FlowControl, ExplainStep{Check: "threshold", Passed: true, Detail: threshold})
+	if opt.QueriesPerDay > 0 {
+		e.FlowControl = append(e.FlowControl, ExplainStep{Check: "quota", Passed: true, Detail: fmt.Sprintf("%v queries per day", opt.QueriesPerDay)})
+	}
+	if opt.QueryBlock {
+		e.FlowControl = append(e.FlowControl, ExplainStep{Check: "suspension", Detail: "resource suspended by queryBlock"})
+		return false
+	}
+	e.FlowControl = append(e.FlowControl, ExplainStep{Check: "suspension", Passed: true, Detail: "not suspended"})
+	return true
+}
+
+//explainUnknownResource trace of unknown resource policy, false returned if request is rejected.
+//tokens left of limited client ip are not traced
+func explainUnknownResource(s *ruleSnapshot, e *Explanation) bool {
+	opts := &s.rule.UnknownResource
+	step := ExplainStep{Check: "unknown", Passed: true, Detail: "unknown resource allowed"}
+	switch strings.ToLower(opts.Action) {
+	case UnknownReject:
+		step = ExplainStep{Check: "unknown", Detail: "unknown resource rejected with 403"}
+	case UnknownLimit:
+		if opts.Threshold > 0 {
+			step.Detail = fmt.Sprintf("unknown resource limited to %v per second with burst %v of client ip, exceeded requests rejected with 429", opts.Threshold, opts.burst())
+		} else {
+			step = ExplainStep{Check: "unknown", Detail: "unknown resource rejected with 429, no threshold configured"}
+		}
+	}
+	e.FlowControl = append(e.FlowControl, step)
+	return step.Passed
+}
+
+func explainDecision(d Decision) string {
+	if len(d.Rule) == 0 {
+		return d.Reason
+	}
+	return d.Reason + ": " + d.Rule
+}
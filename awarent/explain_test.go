@@ -0,0 +1,130 @@
+package awarent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestExplainHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		FlowControlRules: []FlowControlOption{
+			{Resource: "bigdata", Threshold: 10},
+			{Resource: "suspended", Threshold: 10, QueryBlock: true},
+		},
+		IPFilterRules: FilterOptions{
+			URLPath:  "/q",
+			URLParam: "cid",
+			AuthorizedIPs: []Authorized{
+				{Resource: "bigdata", IPS: []string{"192.0.2.0/24"}},
+				{Resource: "suspended", IPS: []string{"192.0.2.0/24"}},
+			},
+		},
+	})
+	e := gin.New()
+	e.GET("/awarent/explain", a.ExplainHandler)
+	cases := []struct {
+		query   string
+		allowed bool
+		reason  string
+	}{
+		{"ip=192.0.2.1&resource=bigdata&path=/q", true, ReasonAuthorized},
+		{"ip=198.51.100.1&resource=bigdata&path=/q", false, ReasonUnauthorized},
+		{"ip=192.0.2.1&resource=suspended&path=/q", false, ReasonAuthorized},
+		{"ip=198.51.100.1&path=/other", true, ReasonDefault},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/awarent/explain?"+c.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s got status %d", c.query, w.Code)
+		}
+		var got Explanation
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Allowed != c.allowed || got.Decision.Reason != c.reason {
+			t.Errorf("%s got allowed %v reason %s, want %v %s", c.query, got.Allowed, got.Decision.Reason, c.allowed, c.reason)
+		}
+		if len(got.IPFilter) == 0 || len(got.FlowControl) == 0 {
+			t.Errorf("%s should trace both ip filter and flow control", c.query)
+		}
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/awarent/explain?ip=bad", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid ip got status %d, want 400", w.Code)
+	}
+}
+
+func TestExplainUnknownResource(t *testing.T) {
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam:    "cid",
+		FlowControlRules: []FlowControlOption{{Resource: "bigdata", Threshold: 10}},
+		IPFilterRules:    FilterOptions{URLPath: "/q", URLParam: "cid"},
+		UnknownResource:  UnknownResourceOptions{Action: UnknownReject},
+	})
+	for resource, allowed := range map[string]bool{"bigdata": true, "other": false, "": false} {
+		e := &Explanation{IP: "192.0.2.1", Method: http.MethodGet, Path: "/q", Resource: resource}
+		if got := explainFlowControl(a.snapshot(), e, "/q"); got != allowed {
+			t.Errorf("resource %q got allowed %v, want %v", resource, got, allowed)
+		}
+		last := e.FlowControl[len(e.FlowControl)-1]
+		if !allowed && (last.Check != "unknown" || last.Passed) {
+			t.Errorf("resource %q got last step %+v, want failed unknown step", resource, last)
+		}
+	}
+}
+
+func TestExplainMaintenance(t *testing.T) {
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		IPFilterRules: FilterOptions{URLPath: "/q", URLParam: "cid"},
+		Maintenance:   MaintenanceOptions{Enabled: true, Resources: []string{"bigdata"}, AllowedIPs: []string{"192.0.2.0/24"}},
+	})
+	cases := []struct {
+		ip, resource, path string
+		allowed            bool
+	}{
+		{"198.51.100.1", "bigdata", "/q", false},
+		{"192.0.2.1", "bigdata", "/q", true},
+		{"198.51.100.1", "other", "/q", true},
+		{"198.51.100.1", "bigdata", "/other", true},
+	}
+	for _, c := range cases {
+		e := &Explanation{IP: c.ip, Method: http.MethodGet, Path: c.path, Resource: c.resource}
+		if got := explainMaintenance(a.snapshot(), e, c.path); got != c.allowed || len(e.Maintenance) == 0 {
+			t.Errorf("%s %s %s got allowed %v trace %+v, want %v", c.ip, c.resource, c.path, got, e.Maintenance, c.allowed)
+		}
+	}
+}
+
+func TestExplainLimits(t *testing.T) {
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		Routes: []RouteRule{{
+			Path:             "/batch",
+			MaxBodyBytes:     8,
+			AllowedMethods:   []string{"POST"},
+			FlowControlRules: []FlowControlOption{{Resource: "bigdata", Threshold: 100}},
+		}},
+	})
+	for method, allowed := range map[string]bool{http.MethodPost: true, http.MethodGet: false} {
+		e := &Explanation{IP: "192.0.2.1", Method: method, Path: "/batch", Resource: "bigdata"}
+		if got := explainFlowControl(a.snapshot(), e, "/batch"); got != allowed {
+			t.Errorf("%s got allowed %v, want %v", method, got, allowed)
+		}
+		if len(e.FlowControl) < 2 || e.FlowControl[1].Check != "limits" || e.FlowControl[1].Passed != allowed {
+			t.Errorf("%s got trace %+v, want limits step", method, e.FlowControl)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package awarent
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//ExtractorOptions declarative resource(cid) extractor shared by ip filter and flow control.
+//Sources are tried in order until a non-empty resource found, format of source is kind:name,
+//kind is one of query, param, header, cookie, jwt. such as query:cid, param:cid, header:X-Client-Id, jwt:sub
+type ExtractorOptions struct {
+	Sources []string   `yaml:"sources"`
+	JWT     JWTOptions `yaml:"jwt"`
+}
+
+//JWTOptions verification of jwt token used by jwt source.
+//Algorithm is one of HS256/HS384/HS512/RS256/RS384/RS512, Key is PEM encoded rsa public key.
+//hmac secret can only be read from KeyFile, the rule is logged on every load
+type JWTOptions struct {
+	Header    string `yaml:"header"`
+	Algorithm string `yaml:"algorithm"`
+	Key       string `yaml:"key"`
+	KeyFile   string `yaml:"keyFile"`
+}
+
+//ResourceExtractor extract resource from request
+type ResourceExtractor func(c *gin.Context) string
+
+const (
+	sourceQuery  = "query"
+	sourceParam  = "param"
+	sourceHeader = "header"
+	sourceCookie = "cookie"
+	sourceJWT    = "jwt"
+)
+
+//NewResourceExtractor compile extractor options to ResourceExtractor, nil returned when no source configured
+func NewResourceExtractor(opts ExtractorOptions) (ResourceExtractor, error) {
+	if len(opts.Sources) == 0 {
+		return nil, nil
+	}
+	var verifier *jwtVerifier
+	var extractors []ResourceExtractor
+	for _, source := range opts.Sources {
+		kind, name, err := parseSource(source)
+		if err != nil {
+			return nil, err
+		}
+		if kind == sourceJWT && verifier == nil {
+			if verifier, err = newJWTVerifier(opts.JWT); err != nil {
+				return nil, err
+			}
+		}
+		extractors = append(extractors, sourceExtractor(kind, name, verifier))
+	}
+	return func(c *gin.Context) string {
+		for _, extract := range extractors {
+			if resource := extract(c); len(resource) > 0 {
+				return resource
+			}
+		}
+		return ""
+	}, nil
+}
+
+func parseSource(source string) (kind, name string, err error) {
+	idx := strings.Index(source, ":")
+	if idx <= 0 || idx == len(source)-1 {
+		return "", "", fmt.Errorf("invalid resource source %q, expect kind:name", source)
+	}
+	kind, name = strings.ToLower(strings.TrimSpace(source[:idx])), strings.TrimSpace(source[idx+1:])
+	switch kind {
+	case sourceQuery, sourceParam, sourceHeader, sourceCookie, sourceJWT:
+		return kind, name, nil
+	}
+	return "", "", fmt.Errorf("unknown resource source kind %q of %q", kind, source)
+}
+
+func sourceExtractor(kind, name string, verifier *jwtVerifier) ResourceExtractor {
+	switch kind {
+	case sourceQuery:
+		return func(c *gin.Context) string { return c.Query(name) }
+	case sourceParam:
+		return func(c *gin.Context) string { return strings.Trim(c.Param(name), "/") }
+	case sourceHeader:
+		return func(c *gin.Context) string { return c.GetHeader(name) }
+	case sourceCookie:
+		return func(c *gin.Context) string {
+			val, _ := c.Cookie(name)
+			return val
+		}
+	default:
+		return func(c *gin.Context) string {
+			val, _ := verifier.claim(c, name)
+			return val
+		}
+	}
+}
+
+var (
+	errTokenMalformed = errors.New("jwt token malformed")
+	errTokenSignature = errors.New("jwt token signature invalid")
+	errTokenExpired   = errors.New("jwt token expired or not valid yet")
+)
+
+type jwtVerifier struct {
+	header    string
+	algorithm string
+	hmacKey   []byte
+	hmacHash  func() hash.Hash
+	rsaKey    *rsa.PublicKey
+	rsaHash   crypto.Hash
+}
+
+func newJWTVerifier(opts JWTOptions) (*jwtVerifier, error) {
+	key := opts.Key
+	if len(opts.KeyFile) > 0 {
+		data, err := ioutil.ReadFile(opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = string(data)
+	}
+	if len(key) == 0 {
+		return nil, errors.New("jwt verification key required")
+	}
+	v := &jwtVerifier{
+		header:    opts.Header,
+		algorithm: strings.ToUpper(opts.Algorithm),
+	}
+	if len(v.header) == 0 {
+		v.header = "Authorization"
+	}
+	if len(v.algorithm) == 0 {
+		v.algorithm = "HS256"
+	}
+	switch v.algorithm {
+	case "HS256":
+		v.hmacHash = sha256.New
+	case "HS384":
+		v.hmacHash = sha512.New384
+	case "HS512":
+		v.hmacHash = sha512.New
+	case "RS256":
+		v.rsaHash = crypto.SHA256
+	case "RS384":
+		v.rsaHash = crypto.SHA384
+	case "RS512":
+		v.rsaHash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", opts.Algorithm)
+	}
+	if v.hmacHash != nil {
+		if len(opts.Key) > 0 {
+			return nil, errors.New("jwt hmac secret must be configured by keyFile")
+		}
+		v.hmacKey = []byte(key)
+		return v, nil
+	}
+	pub, err := parseRSAPublicKey([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	v.rsaKey = pub
+	return v, nil
+}
+
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt key is not PEM encoded")
+	}
+	var pub interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+		return rsaKey, nil
+	}
+	return nil, errors.New("jwt key is not rsa public key")
+}
+
+//token bearer token from configured header
+func (v *jwtVerifier) token(c *gin.Context) string {
+	token := strings.TrimSpace(c.GetHeader(v.header))
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	return token
+}
+
+//claim verify token of request and return claim as string
+func (v *jwtVerifier) claim(c *gin.Context, name string) (string, error) {
+	token := v.token(c)
+	if len(token) == 0 {
+		return "", errTokenMalformed
+	}
+	claims, err := v.verify(token, time.Now())
+	if err != nil {
+		return "", err
+	}
+	switch val := claims[name].(type) {
+	case string:
+		return val, nil
+	case json.Number:
+		return val.String(), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	}
+	return "", nil
+}
+
+func (v *jwtVerifier) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errTokenMalformed
+	}
+	if header.Alg != v.algorithm {
+		return nil, errTokenSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if v.hmacHash != nil {
+		mac := hmac.New(v.hmacHash, v.hmacKey)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errTokenSignature
+		}
+	} else {
+		h := v.rsaHash.New()
+		h.Write(signed)
+		if err := rsa.VerifyPKCS1v15(v.rsaKey, v.rsaHash, h.Sum(nil), sig); err != nil {
+			return nil, errTokenSignature
+		}
+	}
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errTokenMalformed
+	}
+	unix := now.Unix()
+	if exp, ok := numericClaim(claims, "exp"); ok && unix >= exp {
+		return nil, errTokenExpired
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && unix < nbf {
+		return nil, errTokenExpired
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	num, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	if val, err := num.Int64(); err == nil {
+		return val, true
+	}
+	if val, err := num.Float64(); err == nil {
+		return int64(val), true
+	}
+	return 0, false
+}
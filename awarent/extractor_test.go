@@ -0,0 +1,107 @@
+package awarent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func signHS256(t *testing.T, key, payload string) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(signed))
+	return signed + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func extractFrom(t *testing.T, extract ResourceExtractor, route, target string, header http.Header) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var got string
+	e := gin.New()
+	e.GET(route, func(c *gin.Context) {
+		got = extract(c)
+	})
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	return got
+}
+
+func TestResourceExtractorFallback(t *testing.T) {
+	extract, err := NewResourceExtractor(ExtractorOptions{
+		Sources: []string{"query:cid", "header:X-Client-Id", "cookie:cid", "param:cid"},
+	})
+	if err != nil {
+		t.Fatalf("new extractor error:%v", err)
+	}
+	cases := []struct {
+		target string
+		header http.Header
+		want   string
+	}{
+		{"/active/bigdata?cid=ads", nil, "ads"},
+		{"/active/bigdata", http.Header{"X-Client-Id": {"test"}}, "test"},
+		{"/active/bigdata", http.Header{"Cookie": {"cid=cookie"}}, "cookie"},
+		{"/active/bigdata", nil, "bigdata"},
+	}
+	for _, tc := range cases {
+		if got := extractFrom(t, extract, "/active/:cid", tc.target, tc.header); got != tc.want {
+			t.Errorf("extract %s got %q, want %q", tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestResourceExtractorJWT(t *testing.T) {
+	if _, err := NewResourceExtractor(ExtractorOptions{
+		Sources: []string{"jwt:sub"},
+		JWT:     JWTOptions{Algorithm: "HS256", Key: "secret"},
+	}); err == nil {
+		t.Error("inline hmac secret should be rejected")
+	}
+	keyFile := filepath.Join(t.TempDir(), "jwt.key")
+	if err := ioutil.WriteFile(keyFile, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	extract, err := NewResourceExtractor(ExtractorOptions{
+		Sources: []string{"jwt:sub"},
+		JWT:     JWTOptions{Algorithm: "HS256", KeyFile: keyFile},
+	})
+	if err != nil {
+		t.Fatalf("new extractor error:%v", err)
+	}
+	future := time.Now().Add(time.Hour).Unix()
+	valid := signHS256(t, "secret", `{"sub":"bigdata","exp":`+strconv.FormatInt(future, 10)+`}`)
+	expired := signHS256(t, "secret", `{"sub":"bigdata","exp":1}`)
+	forged := signHS256(t, "other", `{"sub":"bigdata"}`)
+	cases := map[string]string{valid: "bigdata", expired: "", forged: "", "garbage": ""}
+	for token, want := range cases {
+		got := extractFrom(t, extract, "/q", "/q", http.Header{"Authorization": {"Bearer " + token}})
+		if got != want {
+			t.Errorf("extract token %s got %q, want %q", token, got, want)
+		}
+	}
+}
+
+func TestResourceExtractorInvalidSource(t *testing.T) {
+	for _, source := range []string{"cid", "query:", "body:cid"} {
+		if _, err := NewResourceExtractor(ExtractorOptions{Sources: []string{source}}); err == nil {
+			t.Errorf("source %q should be invalid", source)
+		}
+	}
+	if _, err := NewResourceExtractor(ExtractorOptions{Sources: []string{"jwt:sub"}}); err == nil {
+		t.Errorf("jwt source without key should be invalid")
+	}
+}
@@ -0,0 +1,135 @@
+package awarent
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//FaultOptions rule driven fault injection for testing callers and downstream services, disabled by default.
+//in production groups(group name containing PROD) faults are injected only if AllowProduction is set
+type FaultOptions struct {
+	Enabled         bool        `yaml:"enabled"`
+	AllowProduction bool        `yaml:"allowProduction"`
+	Faults          []FaultRule `yaml:"faults"`
+}
+
+//FaultRule fault of requests matching Method and Path(gin route pattern such as /active/:cid) and Resources(cid).
+//empty Path matches requests under flow control, empty Method and Resources match any.
+//Delay is added to DelayPercent(0-100) of requests, AbortPercent of requests are aborted with AbortStatus(500 by default).
+//percent is 100 when not configured and 0 means never. the first matched fault rule applies
+type FaultRule struct {
+	Method       string        `yaml:"method"`
+	Path         string        `yaml:"path"`
+	Resources    []string      `yaml:"resources"`
+	Delay        time.Duration `yaml:"delay"`
+	DelayPercent *float64      `yaml:"delayPercent"`
+	Abort        bool          `yaml:"abort"`
+	AbortStatus  int           `yaml:"abortStatus"`
+	AbortPercent *float64      `yaml:"abortPercent"`
+}
+
+//faultRand random number in [0, 1) deciding whether fault is injected
+var faultRand = rand.Float64
+
+//newFaults nil returned when fault injection is disabled, error returned if abort status is not a valid http status
+func newFaults(opts FaultOptions) ([]FaultRule, error) {
+	if !opts.Enabled {
+		return nil, nil
+	}
+	for i, fault := range opts.Faults {
+		if fault.AbortStatus != 0 && (fault.AbortStatus < 100 || fault.AbortStatus > 599) {
+			return nil, fmt.Errorf("fault #%d: invalid abortStatus %d", i+1, fault.AbortStatus)
+		}
+	}
+	return opts.Faults, nil
+}
+
+//productionGroup whether nacos group is a production group
+func productionGroup(group string) bool {
+	return strings.Contains(strings.ToUpper(group), "PROD")
+}
+
+//matchFault first fault rule matching request
+func (s *ruleSnapshot) matchFault(c *gin.Context) (*FaultRule, string, bool) {
+	var cid string
+	resolved := false
+	for i := range s.faults {
+		fault := &s.faults[i]
+		if len(fault.Method) > 0 && fault.Method != anyMethod && !strings.EqualFold(fault.Method, c.Request.Method) {
+			continue
+		}
+		if len(fault.Path) > 0 && fault.Path != c.FullPath() {
+			continue
+		}
+		if len(fault.Path) == 0 && !s.flowProtected(c) {
+			continue
+		}
+		if !resolved {
+			cid, resolved = s.flowResource(c), true
+		}
+		if len(fault.Resources) > 0 && !containsString(fault.Resources, cid) {
+			continue
+		}
+		return fault, cid, true
+	}
+	return nil, "", false
+}
+
+func containsString(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+//hit whether fault of percent is injected, percent is 100 when not configured
+func hit(percent *float64) bool {
+	if percent == nil {
+		return true
+	}
+	return faultRand()*100 < *percent
+}
+
+//FaultInjection fault injection middleware following current rule, use it after Sentinel.
+//delays end early if request is canceled, injected faults are counted by kind
+func (a *Awarent) FaultInjection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := a.requestSnapshot(c)
+		if len(s.faults) == 0 || productionGroup(a.group) && !s.rule.FaultInjection.AllowProduction {
+			c.Next()
+			return
+		}
+		fault, cid, ok := s.matchFault(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		resource := s.resourceName(c, cid)
+		if fault.Delay > 0 && hit(fault.DelayPercent) {
+			faultCount.WithLabelValues("delay", resource).Inc()
+			timer := time.NewTimer(fault.Delay)
+			select {
+			case <-timer.C:
+			case <-c.Request.Context().Done():
+				timer.Stop()
+			}
+		}
+		if fault.Abort && hit(fault.AbortPercent) {
+			status := fault.AbortStatus
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			faultCount.WithLabelValues(fmt.Sprintf("abort-%d", status), resource).Inc()
+			c.AbortWithStatus(status)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,82 @@
+package awarent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFaultInjection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(r func() float64) { faultRand = r }(faultRand)
+	random := 0.0
+	faultRand = func() float64 { return random }
+	percent := func(p float64) *float64 { return &p }
+	rule := Rule{
+		ResourceParam: "cid",
+		IPFilterRules: FilterOptions{URLPath: "/q", URLParam: "cid"},
+		FaultInjection: FaultOptions{
+			Enabled: true,
+			Faults: []FaultRule{
+				{Resources: []string{"slow"}, Delay: 50 * time.Millisecond},
+				{Method: "GET", Path: "/q", Resources: []string{"flaky"}, Abort: true, AbortStatus: http.StatusBadGateway, AbortPercent: percent(30)},
+				{Resources: []string{"never"}, Abort: true, AbortPercent: percent(0)},
+			},
+		},
+	}
+	a := &Awarent{group: "DDV_TEST"}
+	a.setRule(rule)
+	e := gin.New()
+	e.Use(a.FaultInjection())
+	e.GET("/q", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	serve := func(url string) (int, time.Duration) {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w.Code, time.Since(start)
+	}
+	if code, elapsed := serve("/q?cid=slow"); code != http.StatusOK || elapsed < 50*time.Millisecond {
+		t.Errorf("delayed request got %d in %v", code, elapsed)
+	}
+	if code, _ := serve("/q?cid=flaky"); code != http.StatusBadGateway {
+		t.Errorf("aborted request got %d, want 502", code)
+	}
+	random = 0.5
+	if code, _ := serve("/q?cid=flaky"); code != http.StatusOK {
+		t.Errorf("request out of abort percent got %d, want 200", code)
+	}
+	if code, _ := serve("/q?cid=never"); code != http.StatusOK {
+		t.Errorf("request with zero abort percent got %d, want 200", code)
+	}
+	if code, elapsed := serve("/q?cid=other"); code != http.StatusOK || elapsed >= 50*time.Millisecond {
+		t.Errorf("unmatched request got %d in %v", code, elapsed)
+	}
+
+	random = 0
+	a.group = "DDV_PROD"
+	a.setRule(rule)
+	if code, _ := serve("/q?cid=flaky"); code != http.StatusOK {
+		t.Errorf("production group got %d, want 200", code)
+	}
+	rule.FaultInjection.AllowProduction = true
+	a.setRule(rule)
+	if code, _ := serve("/q?cid=flaky"); code != http.StatusBadGateway {
+		t.Errorf("production group allowed got %d, want 502", code)
+	}
+	invalid := rule
+	invalid.FaultInjection.Faults = []FaultRule{{Abort: true, AbortStatus: 42}}
+	if err := a.setRule(invalid); err == nil {
+		t.Error("rule with invalid abort status should be rejected")
+	}
+	if code, _ := serve("/q?cid=flaky"); code != http.StatusBadGateway {
+		t.Errorf("current rule after rejected rule got %d, want 502", code)
+	}
+	rule.FaultInjection.Enabled = false
+	a.setRule(rule)
+	if code, _ := serve("/q?cid=flaky"); code != http.StatusOK {
+		t.Errorf("disabled fault injection got %d, want 200", code)
+	}
+}
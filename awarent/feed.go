@@ -0,0 +1,278 @@
+package awarent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//FeedOptions external blocklist, Source is local file path or http(s) url serving one ip or cidr per line,
+//text after # or ; is comment. feed is refreshed every RefreshInterval(5m by default), url feed is requested with ETag.
+//feed is unavailable until it is loaded, or when the last successful load is older than MaxStale if set.
+//requests not explicitly allowed are rejected while a FailClosed feed is unavailable
+type FeedOptions struct {
+	Name            string        `yaml:"name"`
+	Source          string        `yaml:"source"`
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+	MaxStale        time.Duration `yaml:"maxStale"`
+	FailClosed      bool          `yaml:"failClosed"`
+}
+
+const (
+	defaultFeedRefreshInterval = 5 * time.Minute
+	feedFetchTimeout           = 10 * time.Second
+)
+
+var (
+	feedClient = &http.Client{Timeout: feedFetchTimeout}
+	//maxFeedBytes max size of feed content, larger feed fails to load
+	maxFeedBytes int64 = 64 << 20
+)
+
+//blocklistRef feed referenced by ip filter
+type blocklistRef struct {
+	opts FeedOptions
+	feed *blocklistFeed
+}
+
+//blockedBy whether ip is blocked by feed, matched feed is returned when blocked
+func (r *blocklistRef) blockedBy(ip net.IP, now time.Time) (bool, string) {
+	entries, loaded := r.feed.current()
+	if !loaded.IsZero() && (r.opts.MaxStale <= 0 || now.Sub(loaded) <= r.opts.MaxStale) {
+		if entries.contains(ip) {
+			return true, r.name()
+		}
+		return false, ""
+	}
+	if r.opts.FailClosed {
+		return true, r.name() + " unavailable"
+	}
+	if entries.contains(ip) {
+		return true, r.name()
+	}
+	return false, ""
+}
+
+func (r *blocklistRef) name() string {
+	if len(r.opts.Name) > 0 {
+		return r.opts.Name
+	}
+	return r.opts.Source
+}
+
+//compileFeeds open blocklist feeds of ip filter, feed without source is skipped
+func compileFeeds(feeds []FeedOptions) []*blocklistRef {
+	var refs []*blocklistRef
+	for _, opts := range feeds {
+		if len(strings.TrimSpace(opts.Source)) == 0 {
+			log.Printf("blocklist feed %q ignored: empty source\n", opts.Name)
+			continue
+		}
+		refs = append(refs, &blocklistRef{opts: opts, feed: openBlocklistFeed(opts)})
+	}
+	return refs
+}
+
+//blocklistFeed entries of feed source refreshed in background. feeds are shared by ip filters with the same
+//source and refresh interval, and stop refreshing once no filter references them
+type blocklistFeed struct {
+	key     string
+	source  string
+	mu      sync.Mutex
+	state   atomic.Value
+	etag    string
+	modTime time.Time
+	refs    int
+	ready   chan struct{}
+	stop    chan struct{}
+}
+
+type feedState struct {
+	entries *ipTrie
+	loaded  time.Time
+}
+
+var (
+	blocklistFeedsMu sync.Mutex
+	blocklistFeeds   = map[string]*blocklistFeed{}
+)
+
+func openBlocklistFeed(opts FeedOptions) *blocklistFeed {
+	source := strings.TrimSpace(opts.Source)
+	interval := opts.RefreshInterval
+	if interval <= 0 {
+		interval = defaultFeedRefreshInterval
+	}
+	key := source + "|" + interval.String()
+	blocklistFeedsMu.Lock()
+	feed, ok := blocklistFeeds[key]
+	if ok {
+		feed.refs++
+	} else {
+		feed = &blocklistFeed{key: key, source: source, refs: 1, ready: make(chan struct{}), stop: make(chan struct{})}
+		blocklistFeeds[key] = feed
+	}
+	blocklistFeedsMu.Unlock()
+	if ok {
+		//the first load is done by the opener without holding blocklistFeedsMu
+		<-feed.ready
+		return feed
+	}
+	if err := feed.refresh(time.Now()); err != nil {
+		log.Printf("load blocklist feed %s error:%v\n", source, err)
+	}
+	close(feed.ready)
+	go feed.watch(interval)
+	return feed
+}
+
+//release drop reference of feed, refreshing stops when no reference left
+func (feed *blocklistFeed) release() {
+	blocklistFeedsMu.Lock()
+	defer blocklistFeedsMu.Unlock()
+	if feed.refs--; feed.refs > 0 {
+		return
+	}
+	close(feed.stop)
+	delete(blocklistFeeds, feed.key)
+}
+
+func (feed *blocklistFeed) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := feed.refresh(now); err != nil {
+				log.Printf("refresh blocklist feed %s error:%v\n", feed.source, err)
+			}
+		case <-feed.stop:
+			return
+		}
+	}
+}
+
+//current entries and time of last successful load, zero time if never loaded
+func (feed *blocklistFeed) current() (*ipTrie, time.Time) {
+	state, _ := feed.state.Load().(*feedState)
+	if state == nil {
+		return nil, time.Time{}
+	}
+	return state.entries, state.loaded
+}
+
+//refresh reload feed if source changed, current entries are kept on error
+func (feed *blocklistFeed) refresh(now time.Time) error {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	var content []byte
+	var err error
+	if strings.HasPrefix(feed.source, "http://") || strings.HasPrefix(feed.source, "https://") {
+		content, err = feed.fetchURL()
+	} else {
+		content, err = feed.readFile()
+	}
+	if err != nil {
+		return err
+	}
+	entries, _ := feed.current()
+	if content != nil {
+		var invalid int
+		if entries, invalid, err = parseBlocklist(bytes.NewReader(content)); err != nil {
+			return err
+		}
+		log.Printf("blocklist feed %s loaded, %d entries, %d invalid lines ignored\n", feed.source, entries.size, invalid)
+	}
+	feed.state.Store(&feedState{entries: entries, loaded: now})
+	return nil
+}
+
+//readFile content of file feed, nil returned if file not modified
+func (feed *blocklistFeed) readFile() ([]byte, error) {
+	stat, err := os.Stat(feed.source)
+	if err != nil {
+		return nil, err
+	}
+	if stat.ModTime().Equal(feed.modTime) {
+		return nil, nil
+	}
+	if stat.Size() > maxFeedBytes {
+		return nil, fmt.Errorf("feed larger than %d bytes", maxFeedBytes)
+	}
+	content, err := ioutil.ReadFile(feed.source)
+	if err != nil {
+		return nil, err
+	}
+	feed.modTime = stat.ModTime()
+	return content, nil
+}
+
+//fetchURL content of url feed, nil returned if server responds 304 Not Modified
+func (feed *blocklistFeed) fetchURL() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, feed.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(feed.etag) > 0 {
+		req.Header.Set("If-None-Match", feed.etag)
+	}
+	resp, err := feedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if _, loaded := feed.current(); !loaded.IsZero() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("not modified before loaded")
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxFeedBytes {
+		return nil, fmt.Errorf("feed larger than %d bytes", maxFeedBytes)
+	}
+	feed.etag = resp.Header.Get("ETag")
+	return content, nil
+}
+
+//parseBlocklist parse ip or cidr per line, number of invalid lines is returned.
+//error returned if content can not be scanned, such as a line too long
+func parseBlocklist(r io.Reader) (*ipTrie, int, error) {
+	entries := newIPTrie()
+	invalid := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexAny(line, "#;"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !entries.insertEntry(fields[0], true, true) {
+			invalid++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return entries, invalid, nil
+}
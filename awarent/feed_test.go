@@ -0,0 +1,142 @@
+package awarent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	entries, invalid, err := parseBlocklist(strings.NewReader("# comment\n1.2.3.4\n10.0.0.0/8 ; spamhaus\n\nbad\n2001:db8::/32\n"))
+	if err != nil || entries.size != 3 || invalid != 1 {
+		t.Fatalf("got %d entries %d invalid error %v, want 3 and 1", entries.size, invalid, err)
+	}
+	if _, _, err := parseBlocklist(strings.NewReader("1.2.3.4\n" + strings.Repeat("#", 1<<17) + "\n5.6.7.8\n")); err == nil {
+		t.Errorf("line too long should fail the blocklist instead of truncating it")
+	}
+}
+
+func TestFeedTooLarge(t *testing.T) {
+	defer func(max int64) { maxFeedBytes = max }(maxFeedBytes)
+	maxFeedBytes = 8
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("8.8.8.0/24\n"))
+	}))
+	defer server.Close()
+	feed := &blocklistFeed{source: server.URL}
+	if err := feed.refresh(time.Now()); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("feed over size limit got error %v", err)
+	}
+}
+
+func TestFileFeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blocklist.txt")
+	if err := ioutil.WriteFile(path, []byte("5.5.5.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := New(FilterOptions{
+		AllowedIPs: []string{"5.5.5.5"},
+		BlockedIPs: []string{"6.6.6.6"},
+		Feeds:      []FeedOptions{{Name: "local", Source: path}},
+	})
+	cases := map[string]bool{
+		"5.5.5.5": true,
+		"5.5.5.6": false,
+		"6.6.6.6": false,
+		"7.7.7.7": true,
+	}
+	for ip, want := range cases {
+		if got := f.Allowed(ip); got != want {
+			t.Errorf("ip %s got %v, want %v", ip, got, want)
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte("7.7.7.7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if err := f.feeds[0].feed.refresh(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if f.Allowed("7.7.7.7") || !f.Allowed("5.5.5.6") {
+		t.Errorf("feed should be reloaded after file changed")
+	}
+}
+
+func TestURLFeedETag(t *testing.T) {
+	var fetched, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("8.8.8.0/24\n"))
+	}))
+	defer server.Close()
+	f := New(FilterOptions{Feeds: []FeedOptions{{Source: server.URL + "/list"}}})
+	if f.Allowed("8.8.8.8") {
+		t.Errorf("ip in url feed should be blocked")
+	}
+	if err := f.feeds[0].feed.refresh(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&fetched) != 2 || atomic.LoadInt32(&notModified) != 1 {
+		t.Errorf("refresh should be conditional on etag")
+	}
+	if f.Allowed("8.8.8.8") {
+		t.Errorf("entries should be kept when not modified")
+	}
+}
+
+func TestFeedUnavailable(t *testing.T) {
+	source := filepath.Join(os.TempDir(), "awarent-missing-feed.txt")
+	open := New(FilterOptions{Feeds: []FeedOptions{{Source: source}}})
+	if !open.Allowed("9.9.9.9") {
+		t.Errorf("unavailable feed should fail open by default")
+	}
+	closed := New(FilterOptions{
+		AllowedIPs: []string{"9.9.9.9"},
+		Feeds:      []FeedOptions{{Source: source, FailClosed: true}},
+	})
+	if closed.Allowed("9.9.9.10") {
+		t.Errorf("unavailable feed should fail closed")
+	}
+	if !closed.Allowed("9.9.9.9") {
+		t.Errorf("explicitly allowed ip should pass closed feed")
+	}
+}
+
+func TestFeedShared(t *testing.T) {
+	source := "/nonexistent/blocklist.txt"
+	a := &Awarent{}
+	a.setRule(Rule{IPFilterRules: FilterOptions{Feeds: []FeedOptions{{Source: source, RefreshInterval: time.Minute}}}})
+	a.setRule(Rule{IPFilterRules: FilterOptions{Feeds: []FeedOptions{{Source: source, RefreshInterval: time.Hour}}}})
+	blocklistFeedsMu.Lock()
+	_, minute := blocklistFeeds[source+"|1m0s"]
+	hour, ok := blocklistFeeds[source+"|1h0m0s"]
+	blocklistFeedsMu.Unlock()
+	if minute || !ok || hour.refs != 1 {
+		t.Errorf("feed of replaced rule should be released, refresh interval of current rule should apply")
+	}
+	a.setRule(Rule{})
+	blocklistFeedsMu.Lock()
+	_, ok = blocklistFeeds[source+"|1h0m0s"]
+	blocklistFeedsMu.Unlock()
+	if ok {
+		t.Errorf("feed removed from rule should stop refreshing")
+	}
+}
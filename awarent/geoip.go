@@ -0,0 +1,265 @@
+package awarent
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+//GeoOptions country and ASN based filtering from local MaxMind mmdb files. Database is country or city database,
+//ASNDatabase is ASN database, ASN is looked up from Database when ASNDatabase is empty.
+//deny lists are checked first, then ip must match allow lists if configured.
+//ips not found in database are allowed when AllowUnknown or no allow list configured
+type GeoOptions struct {
+	Database       string        `yaml:"database"`
+	ASNDatabase    string        `yaml:"asnDatabase"`
+	AllowCountries []string      `yaml:"allowCountries"`
+	DenyCountries  []string      `yaml:"denyCountries"`
+	AllowASNs      []uint        `yaml:"allowASNs"`
+	DenyASNs       []uint        `yaml:"denyASNs"`
+	AllowUnknown   bool          `yaml:"allowUnknown"`
+	CheckInterval  time.Duration `yaml:"checkInterval"`
+}
+
+//GeoInfo geo information of ip
+type GeoInfo struct {
+	Country string `json:"country"`
+	ASN     uint   `json:"asn"`
+	Found   bool   `json:"found"`
+}
+
+type geoLookup func(ip net.IP) GeoInfo
+
+type geoFilter struct {
+	lookup         geoLookup
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+	allowASNs      map[uint]bool
+	denyASNs       map[uint]bool
+	allowUnknown   bool
+	databases      []*geoDatabase
+}
+
+//newGeoFilter nil returned when no database configured
+func newGeoFilter(opts GeoOptions) *geoFilter {
+	if len(opts.Database) == 0 && len(opts.ASNDatabase) == 0 {
+		return nil
+	}
+	interval := opts.CheckInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	var countryDB, asnDB *geoDatabase
+	var databases []*geoDatabase
+	if len(opts.Database) > 0 {
+		countryDB = openGeoDatabase(opts.Database, interval)
+		databases = append(databases, countryDB)
+	}
+	asnDB = countryDB
+	if len(opts.ASNDatabase) > 0 {
+		asnDB = openGeoDatabase(opts.ASNDatabase, interval)
+		databases = append(databases, asnDB)
+	}
+	g := newGeoPolicy(opts, func(ip net.IP) GeoInfo {
+		var info GeoInfo
+		if countryDB != nil {
+			var record geoCountryRecord
+			if countryDB.lookup(ip, &record) {
+				info.Country = record.country()
+				info.Found = true
+			}
+		}
+		if asnDB != nil {
+			var record geoASNRecord
+			if asnDB.lookup(ip, &record) && record.ASN > 0 {
+				info.ASN = record.ASN
+				info.Found = true
+			}
+		}
+		return info
+	})
+	g.databases = databases
+	return g
+}
+
+//close release databases of geo filter
+func (g *geoFilter) close() {
+	if g == nil {
+		return
+	}
+	for _, db := range g.databases {
+		db.release()
+	}
+}
+
+func newGeoPolicy(opts GeoOptions, lookup geoLookup) *geoFilter {
+	g := &geoFilter{
+		lookup:         lookup,
+		allowCountries: map[string]bool{},
+		denyCountries:  map[string]bool{},
+		allowASNs:      map[uint]bool{},
+		denyASNs:       map[uint]bool{},
+		allowUnknown:   opts.AllowUnknown,
+	}
+	for _, country := range opts.AllowCountries {
+		g.allowCountries[strings.ToUpper(country)] = true
+	}
+	for _, country := range opts.DenyCountries {
+		g.denyCountries[strings.ToUpper(country)] = true
+	}
+	for _, asn := range opts.AllowASNs {
+		g.allowASNs[asn] = true
+	}
+	for _, asn := range opts.DenyASNs {
+		g.denyASNs[asn] = true
+	}
+	return g
+}
+
+//allowed whether ip passes geo filter, reason is returned when denied
+func (g *geoFilter) allowed(ip net.IP) (bool, string) {
+	if g == nil || ip == nil {
+		return true, ""
+	}
+	info := g.lookup(ip)
+	if !info.Found {
+		if g.allowUnknown || (len(g.allowCountries) == 0 && len(g.allowASNs) == 0) {
+			return true, ""
+		}
+		return false, "geo unknown"
+	}
+	if g.denyCountries[info.Country] {
+		return false, "country " + info.Country + " denied"
+	}
+	if g.denyASNs[info.ASN] {
+		return false, "asn denied"
+	}
+	if len(g.allowCountries) > 0 && !g.allowCountries[info.Country] {
+		return false, "country " + info.Country + " not allowed"
+	}
+	if len(g.allowASNs) > 0 && !g.allowASNs[info.ASN] {
+		return false, "asn not allowed"
+	}
+	return true, ""
+}
+
+type geoCountryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+func (r *geoCountryRecord) country() string {
+	if len(r.Country.ISOCode) > 0 {
+		return r.Country.ISOCode
+	}
+	return r.RegisteredCountry.ISOCode
+}
+
+type geoASNRecord struct {
+	ASN uint `maxminddb:"autonomous_system_number"`
+}
+
+//geoDatabase mmdb file reopened when its modification time changed. databases are shared by geo filters
+//with the same path and check interval, and stop checking once no filter references them
+type geoDatabase struct {
+	key     string
+	path    string
+	reader  atomic.Value
+	modTime time.Time
+	refs    int
+	stop    chan struct{}
+}
+
+var (
+	geoDatabasesMu sync.Mutex
+	geoDatabases   = map[string]*geoDatabase{}
+)
+
+func openGeoDatabase(path string, interval time.Duration) *geoDatabase {
+	key := path + "|" + interval.String()
+	geoDatabasesMu.Lock()
+	defer geoDatabasesMu.Unlock()
+	if db, ok := geoDatabases[key]; ok {
+		db.refs++
+		return db
+	}
+	db := &geoDatabase{key: key, path: path, refs: 1, stop: make(chan struct{})}
+	db.reload()
+	geoDatabases[key] = db
+	go db.watch(interval)
+	return db
+}
+
+//release drop reference of database, checking stops when no reference left.
+//the last reader is closed after lookups in flight are done
+func (db *geoDatabase) release() {
+	geoDatabasesMu.Lock()
+	defer geoDatabasesMu.Unlock()
+	if db.refs--; db.refs > 0 {
+		return
+	}
+	close(db.stop)
+	delete(geoDatabases, db.key)
+	time.AfterFunc(time.Minute, func() {
+		if reader, _ := db.reader.Load().(*maxminddb.Reader); reader != nil {
+			reader.Close()
+		}
+	})
+}
+
+func (db *geoDatabase) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.reload()
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+//reload reopen database if file changed, current reader is kept on error
+func (db *geoDatabase) reload() {
+	stat, err := os.Stat(db.path)
+	if err != nil {
+		log.Printf("stat geo database %s error:%v\n", db.path, err)
+		return
+	}
+	if stat.ModTime().Equal(db.modTime) {
+		return
+	}
+	reader, err := maxminddb.Open(db.path)
+	if err != nil {
+		log.Printf("open geo database %s error:%v\n", db.path, err)
+		return
+	}
+	old, _ := db.reader.Load().(*maxminddb.Reader)
+	db.reader.Store(reader)
+	db.modTime = stat.ModTime()
+	log.Printf("geo database %s loaded, build at %s\n", db.path, time.Unix(int64(reader.Metadata.BuildEpoch), 0).Format(time.RFC3339))
+	if old != nil {
+		//requests in flight may still use old reader
+		time.AfterFunc(time.Minute, func() { old.Close() })
+	}
+}
+
+func (db *geoDatabase) lookup(ip net.IP, record interface{}) bool {
+	reader, _ := db.reader.Load().(*maxminddb.Reader)
+	if reader == nil {
+		return false
+	}
+	_, ok, err := reader.LookupNetwork(ip, record)
+	return err == nil && ok
+}
@@ -0,0 +1,69 @@
+package awarent
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGeoPolicy(t *testing.T) {
+	infos := map[string]GeoInfo{
+		"1.0.1.1": {Country: "CN", ASN: 4134, Found: true},
+		"3.3.3.3": {Country: "US", ASN: 16509, Found: true},
+		"1.0.2.2": {Country: "CN", ASN: 37963, Found: true},
+	}
+	lookup := func(ip net.IP) GeoInfo { return infos[ip.String()] }
+	g := newGeoPolicy(GeoOptions{
+		AllowCountries: []string{"cn"},
+		DenyASNs:       []uint{37963},
+	}, lookup)
+	cases := map[string]bool{
+		"1.0.1.1":  true,
+		"3.3.3.3":  false,
+		"1.0.2.2":  false,
+		"10.0.0.1": false,
+	}
+	for ip, want := range cases {
+		if got, reason := g.allowed(net.ParseIP(ip)); got != want {
+			t.Errorf("ip %s got %v(%s), want %v", ip, got, reason, want)
+		}
+	}
+	g.allowUnknown = true
+	if ok, _ := g.allowed(net.ParseIP("10.0.0.1")); !ok {
+		t.Errorf("unknown ip should be allowed with allowUnknown")
+	}
+}
+
+func TestGeoExplicitAllowed(t *testing.T) {
+	f := New(FilterOptions{AllowedIPs: []string{"3.3.3.0/24"}})
+	f.geo = newGeoPolicy(GeoOptions{DenyCountries: []string{"US"}}, func(ip net.IP) GeoInfo {
+		return GeoInfo{Country: "US", Found: true}
+	})
+	if ok, _ := f.geoAllowed("3.3.3.3", time.Now()); !ok {
+		t.Errorf("explicitly allowed ip should skip geo filter")
+	}
+	if ok, _ := f.geoAllowed("4.4.4.4", time.Now()); ok {
+		t.Errorf("denied country should be filtered")
+	}
+}
+
+func TestGeoDatabaseShared(t *testing.T) {
+	path := "/nonexistent/GeoLite2-Country.mmdb"
+	a := &Awarent{}
+	a.setRule(Rule{IPFilterRules: FilterOptions{GeoIP: GeoOptions{Database: path, CheckInterval: time.Minute}}})
+	a.setRule(Rule{IPFilterRules: FilterOptions{GeoIP: GeoOptions{Database: path, CheckInterval: time.Hour}}})
+	geoDatabasesMu.Lock()
+	_, minute := geoDatabases[path+"|1m0s"]
+	hour, ok := geoDatabases[path+"|1h0m0s"]
+	geoDatabasesMu.Unlock()
+	if minute || !ok || hour.refs != 1 {
+		t.Errorf("database of replaced rule should be released, check interval of current rule should apply")
+	}
+	a.setRule(Rule{})
+	geoDatabasesMu.Lock()
+	_, ok = geoDatabases[path+"|1h0m0s"]
+	geoDatabasesMu.Unlock()
+	if ok {
+		t.Errorf("database no longer referenced should be released")
+	}
+}
@@ -1,160 +1,436 @@
 package awarent
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"net"
-	"sync"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
 //FilterOptions for IPFilter. Allow/Block setting
 type FilterOptions struct {
 	//explicity allowed IPs
-	AllowedIPs     []string     `yaml:"allowed"`
-	BlockedIPs     []string     `yaml:"blocked"`
-	URLPath        string       `yaml:"urlPath"`
-	URLParam       string       `yaml:"urlParam"`
-	AuthorizedIPs  []Authorized `yaml:"authorized"`
-	BlockByDefault bool         `yaml:"blockedDefault"`
+	AllowedIPs     []string      `yaml:"allowed"`
+	BlockedIPs     []string      `yaml:"blocked"`
+	URLPath        string        `yaml:"urlPath"`
+	URLParam       string        `yaml:"urlParam"`
+	URLPathParam   string        `yaml:"urlPathParam"`
+	AuthorizedIPs  []Authorized  `yaml:"authorized"`
+	BlockByDefault bool          `yaml:"blockedDefault"`
+	Paths          []PathOptions `yaml:"paths"`
+	//allowed and blocked entries active by schedule only
+	TimedAllowed []TimedEntries `yaml:"timedAllowed"`
+	TimedBlocked []TimedEntries `yaml:"timedBlocked"`
+	//named ip groups referenced by authorized entries, group entries are ip, cidr or hostname
+	Groups map[string][]string `yaml:"groups"`
+	//interval of re-resolving hostname entries, 5m by default
+	ResolveInterval time.Duration `yaml:"resolveInterval"`
+	//proxies(ip or cidr) whose client ip headers are trusted, headers are ignored if empty
+	TrustedProxies []string `yaml:"trustedProxies"`
+	//precedence of client ip sources, such as proxy-protocol, X-Real-IP, X-Forwarded-For
+	ClientIPHeaders []string `yaml:"clientIPHeaders"`
+	//automatic temporary ip bans
+	Bans BanOptions `yaml:"bans"`
+	//country and ASN based filtering, applied before authorization
+	GeoIP GeoOptions `yaml:"geoip"`
+	//external blocklists merged with blocked entries
+	Feeds []FeedOptions `yaml:"feeds"`
+	//decision audit log
+	Audit AuditOptions `yaml:"audit"`
+	//client certificate identities authorized for resource, instead of ip
+	AuthorizedCerts []AuthorizedCert `yaml:"authorizedCerts"`
+	//per resource api keys, combined with or replacing ip authorization
+	APIKeys APIKeyOptions `yaml:"apiKeys"`
+	//HMAC request signing of resources
+	Signing SigningOptions `yaml:"signing"`
+	//expression policies evaluated in order before authorization
+	Policies []PolicyOptions `yaml:"policies"`
 }
 
+//Authorized ips and ip groups authorized for resource, resource * authorizes all resources.
+//authorization is active by schedule when notBefore, expires or windows configured
 type Authorized struct {
 	Resource string   `yaml:"resource"`
 	IPS      []string `yaml:"ips"`
+	Groups   []string `yaml:"groups"`
+	Schedule `yaml:",inline"`
 }
 
-//Filter filter struct
+//anyResource resource of authorized entry authorizing all resources
+const anyResource = "*"
+
+//Filter filter struct. allowed and blocked entries are ip, cidr or hostname, the most specific network with active entry decides,
+//allowed entry wins when the same network is both allowed and blocked. blocklist feeds apply to ips matching no entry.
+//Filter is immutable once created, rule change builds a new Filter
 type Filter struct {
 	opts           FilterOptions
-	mut            sync.RWMutex
 	defaultAllowed bool
-	allowedIPs     map[string]bool
 	urlPath        string
 	urlParam       string
-	blockedIPs     map[string]bool
-	authorizedIPs  map[string][]string
+	pathParam      string
+	accessIPs      *ipTrie
+	authorizedIPs  authorizedTable
+	paths          []*pathFilter
+	warnings       []string
+	errs           []string
+	hostnames      bool
+	geo            *geoFilter
+	feeds          []*blocklistRef
+	apiKeys        apiKeyTable
+	certs          certTable
+	policies       policyList
 }
 
-var ipfilter *Filter
+const defaultResolveInterval = 5 * time.Minute
 
-//New new ipfilter
-func New(opts FilterOptions) *Filter {
+//accessEntry allowed or blocked entry of network, entry is the configured ip, cidr or hostname
+type accessEntry struct {
+	allowed  bool
+	entry    string
+	schedule *schedule
+}
 
-	f := &Filter{
-		opts:           opts,
-		allowedIPs:     map[string]bool{},
-		blockedIPs:     map[string]bool{},
-		authorizedIPs:  map[string][]string{},
-		defaultAllowed: !opts.BlockByDefault,
+//accessEntries entries of the same network
+type accessEntries []accessEntry
+
+//active entry of network at now, allowed entry is preferred
+func (entries accessEntries) active(now time.Time) (accessEntry, bool) {
+	var blocked *accessEntry
+	for i := range entries {
+		if !entries[i].schedule.active(now) {
+			continue
+		}
+		if entries[i].allowed {
+			return entries[i], true
+		}
+		if blocked == nil {
+			blocked = &entries[i]
+		}
+	}
+	if blocked != nil {
+		return *blocked, true
 	}
-	f.urlParam = opts.URLParam
-	f.urlPath = opts.URLPath
+	return accessEntry{}, false
+}
+
+//addAccess add entry of network to access trie
+func addAccess(t *ipTrie, network string, entry accessEntry) {
+	t.updateEntry(network, func(old interface{}) interface{} {
+		entries, _ := old.(accessEntries)
+		return append(entries, entry)
+	})
+}
 
+//authorizedTable authorized networks of resource, value of network is schedules of authorization
+type authorizedTable map[string]*ipTrie
+
+//New new ipfilter, hostname entries are resolved on creation
+func New(opts FilterOptions) *Filter {
+	resolver := &entryResolver{groups: opts.Groups}
+	accessIPs := newIPTrie()
 	for _, ip := range opts.AllowedIPs {
-		f.allowIP(ip)
+		for _, entry := range resolver.expand(ip) {
+			addAccess(accessIPs, entry, accessEntry{allowed: true, entry: ip})
+		}
 	}
 	for _, ip := range opts.BlockedIPs {
-		f.blockIP(ip)
+		for _, entry := range resolver.expand(ip) {
+			addAccess(accessIPs, entry, accessEntry{allowed: false, entry: ip})
+		}
 	}
-	for _, authrozied := range opts.AuthorizedIPs {
-		for _, ip := range authrozied.IPS {
-			f.authorizeIP(ip, authrozied.Resource)
+	for i, timed := range append(append([]TimedEntries(nil), opts.TimedAllowed...), opts.TimedBlocked...) {
+		s, err := timed.Schedule.compile()
+		if err != nil {
+			resolver.errs = append(resolver.errs, fmt.Sprintf("schedule of %v: %v", timed.IPS, err))
+			continue
 		}
+		for _, ip := range timed.IPS {
+			for _, entry := range resolver.expand(ip) {
+				addAccess(accessIPs, entry, accessEntry{allowed: i < len(opts.TimedAllowed), entry: ip, schedule: s})
+			}
+		}
+	}
+	authorizedIPs := newAuthorizedTable(opts.AuthorizedIPs, resolver)
+	paths, pathErrs := compilePaths(opts.Paths, resolver)
+	errs := append(resolver.errs, pathErrs...)
+	for _, warning := range resolver.warnings {
+		log.Printf("ip filter entry ignored:%s\n", warning)
+	}
+	apiKeys, keyWarnings := newAPIKeyTable(opts.APIKeys.Keys)
+	for _, warning := range keyWarnings {
+		log.Printf("api key ignored:%s\n", warning)
+	}
+	policies, policyErrors := compilePolicies(opts.Policies)
+	for _, err := range policyErrors {
+		errs = append(errs, err.Error())
+	}
 
+	return &Filter{
+		opts:           opts,
+		defaultAllowed: !opts.BlockByDefault,
+		urlParam:       opts.URLParam,
+		urlPath:        opts.URLPath,
+		pathParam:      opts.URLPathParam,
+		accessIPs:      accessIPs,
+		authorizedIPs:  authorizedIPs,
+		paths:          paths,
+		warnings:       resolver.warnings,
+		errs:           errs,
+		hostnames:      resolver.hostnames > 0,
+		geo:            newGeoFilter(opts.GeoIP),
+		feeds:          compileFeeds(opts.Feeds),
+		apiKeys:        apiKeys,
+		certs:          newCertTable(opts.AuthorizedCerts),
+		policies:       policies,
 	}
-	return f
 }
 
-func updateIPFilter(opts FilterOptions) {
-	if ipfilter == nil {
-		ipfilter = &Filter{
-			opts:           opts,
-			mut:            sync.RWMutex{},
-			allowedIPs:     map[string]bool{},
-			blockedIPs:     map[string]bool{},
-			authorizedIPs:  map[string][]string{},
-			defaultAllowed: !opts.BlockByDefault,
+//resolveInterval interval of re-resolving hostname entries
+func (f *Filter) resolveInterval() time.Duration {
+	if f.opts.ResolveInterval > 0 {
+		return f.opts.ResolveInterval
+	}
+	return defaultResolveInterval
+}
+
+//Close stop background refresh of geo databases and blocklist feeds no longer referenced by other filters.
+//filter keeps working with the data loaded last, Close is called once
+func (f *Filter) Close() {
+	f.geo.close()
+	for _, ref := range f.feeds {
+		ref.feed.release()
+	}
+}
+
+//Err errors of options which can not be applied safely, such as invalid schedule, protected path or policy.
+//ip filter with errors must not be used, otherwise requests on the invalid paths are not filtered and invalid policies are skipped
+func (f *Filter) Err() error {
+	if len(f.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid ip filter rules: %s", strings.Join(f.errs, "; "))
+}
+
+//Warnings entries ignored on creation, which are neither ip, cidr nor resolvable hostname, and invalid policies
+func (f *Filter) Warnings() []string {
+	return append([]string(nil), f.warnings...)
+}
+
+//compilePaths compile protected paths, errors of invalid paths are returned
+func compilePaths(paths []PathOptions, resolver *entryResolver) ([]*pathFilter, []string) {
+	var compiled []*pathFilter
+	var errs []string
+	for _, opts := range paths {
+		p, err := newPathFilter(opts, resolver)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
 		}
+		compiled = append(compiled, p)
+	}
+	return compiled, errs
+}
+
+//lookupIP resolve hostname entries
+var lookupIP = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return net.DefaultResolver.LookupIPAddr(ctx, host)
+}
+
+//entryResolver expand ip filter entries, hostname is resolved to its addresses.
+//entries neither ip, cidr nor resolvable hostname are collected as warnings, invalid schedules as errors
+type entryResolver struct {
+	warnings  []string
+	errs      []string
+	hostnames int
+	groups    map[string][]string
+}
+
+//expandGroup expand entries of named ip group
+func (r *entryResolver) expandGroup(name string) []string {
+	group, ok := r.groups[name]
+	if !ok {
+		r.warnings = append(r.warnings, fmt.Sprintf("ip group %q is not defined", name))
+		return nil
 	}
+	var entries []string
+	for _, entry := range group {
+		entries = append(entries, r.expand(entry)...)
+	}
+	return entries
+}
 
-	ipfilter.urlParam = opts.URLParam
-	ipfilter.urlPath = opts.URLPath
-	for k := range ipfilter.allowedIPs {
-		delete(ipfilter.allowedIPs, k)
+func (r *entryResolver) expand(entry string) []string {
+	entry = strings.TrimSpace(entry)
+	if _, _, ok := parseNetwork(entry); ok {
+		return []string{entry}
 	}
-	for _, ip := range opts.AllowedIPs {
-		ipfilter.allowIP(ip)
+	if !isHostname(entry) {
+		r.warnings = append(r.warnings, fmt.Sprintf("%q is not ip, cidr or hostname", entry))
+		return nil
 	}
-	for k := range ipfilter.blockedIPs {
-		delete(ipfilter.blockedIPs, k)
+	r.hostnames++
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	addrs, err := lookupIP(ctx, entry)
+	if err != nil || len(addrs) == 0 {
+		r.warnings = append(r.warnings, fmt.Sprintf("hostname %q can not be resolved: %v", entry, err))
+		return nil
 	}
-	for _, ip := range opts.BlockedIPs {
-		ipfilter.blockIP(ip)
+	entries := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		entries = append(entries, addr.IP.String())
 	}
+	return entries
+}
 
-	for k := range ipfilter.authorizedIPs {
-		delete(ipfilter.authorizedIPs, k)
+func isHostname(entry string) bool {
+	if len(entry) == 0 || len(entry) > 253 {
+		return false
 	}
-	for _, authrozied := range opts.AuthorizedIPs {
-		for _, ip := range authrozied.IPS {
-			ipfilter.authorizeIP(ip, authrozied.Resource)
+	for _, ch := range entry {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '-', ch == '.':
+		default:
+			return false
 		}
+	}
+	return true
+}
 
+//normalizeIP normalize client ip, ipv4-mapped ipv6 address is converted to ipv4 and ipv6 zone is dropped.
+//empty string returned if ip is invalid
+func normalizeIP(ip string) string {
+	if idx := strings.IndexByte(ip, '%'); idx >= 0 {
+		ip = ip[:idx]
+	}
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return ""
 	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String()
+	}
+	return parsed.String()
 }
 
-//allowIP  settting allow ip address
-func (f *Filter) allowIP(ip string) bool {
-	if ip := net.ParseIP(ip); ip != nil {
-		f.mut.Lock()
-		f.allowedIPs[ip.String()] = true
-		f.mut.Unlock()
-		return true
+//newAuthorizedTable build authorized table, invalid entry is skipped and invalid schedule is reported as error
+func newAuthorizedTable(entries []Authorized, resolver *entryResolver) authorizedTable {
+	table := authorizedTable{}
+	for _, authorized := range entries {
+		s, err := authorized.Schedule.compile()
+		if err != nil {
+			resolver.errs = append(resolver.errs, fmt.Sprintf("schedule of resource %s: %v", authorized.Resource, err))
+			continue
+		}
+		for _, ip := range authorized.IPS {
+			for _, entry := range resolver.expand(ip) {
+				table.add(entry, authorized.Resource, s)
+			}
+		}
+		for _, group := range authorized.Groups {
+			for _, entry := range resolver.expandGroup(group) {
+				table.add(entry, authorized.Resource, s)
+			}
+		}
 	}
-	return false
+	return table
 }
 
-//blockIP setting block ip address
-func (f *Filter) blockIP(ip string) bool {
-	if ip := net.ParseIP(ip); ip != nil {
-		f.mut.Lock()
-		f.blockedIPs[ip.String()] = true
-		f.mut.Unlock()
-		return true
+func (t authorizedTable) add(ip string, identity string, s *schedule) bool {
+	if len(identity) == 0 {
+		return false
 	}
-	return false
+	trie, ok := t[identity]
+	if !ok {
+		trie = newIPTrie()
+	}
+	if !trie.updateEntry(ip, func(old interface{}) interface{} {
+		schedules, _ := old.([]*schedule)
+		return append(schedules, s)
+	}) {
+		return false
+	}
+	t[identity] = trie
+	return true
 }
 
-//authorizeIP settting service authorized ip address
-func (f *Filter) authorizeIP(ip string, identity string) bool {
-	if ip := net.ParseIP(ip); ip != nil && len(identity) > 0 {
-		f.mut.Lock()
-		val, ok := f.authorizedIPs[identity]
-		if ok {
-			val = append(val, ip.String())
-			f.authorizedIPs[identity] = val
-		} else {
-			val = make([]string, 0)
-			val = append(val, ip.String())
-			f.authorizedIPs[identity] = val
+//authorized whether ip is authorized for identity or any resource by active schedule at now
+func (t authorizedTable) authorized(ip net.IP, identity string, now time.Time) bool {
+	if ip == nil || len(identity) == 0 {
+		return false
+	}
+	active := func(value interface{}) bool {
+		for _, s := range value.([]*schedule) {
+			if s.active(now) {
+				return true
+			}
 		}
-		f.mut.Unlock()
+		return false
+	}
+	if _, ok := t[identity].lookupFunc(ip, active); ok {
 		return true
 	}
-	return false
+	_, ok := t[anyResource].lookupFunc(ip, active)
+	return ok
 }
 
 func (f *Filter) Allowed(ip string) bool {
-	if ip == "" {
-		return f.defaultAllowed
+	return f.access(ip, time.Now()).Allowed
+}
+
+//access decision of allowed and blocked entries and blocklist feeds
+func (f *Filter) access(ip string, now time.Time) Decision {
+	parsed := net.ParseIP(normalizeIP(ip))
+	if parsed == nil {
+		return f.defaultDecision()
 	}
-	allowed, ok := f.allowedIPs[ip]
-	if ok {
-		return allowed
+	if entry, ok := f.lookupAccess(parsed, now); ok {
+		if entry.allowed {
+			return Decision{Allowed: true, Reason: ReasonAllowed, Rule: entry.entry}
+		}
+		return Decision{Reason: ReasonBlocked, Rule: entry.entry}
 	}
-	blocked, ok := f.blockedIPs[ip]
-	if ok {
-		return !blocked
+	for _, feed := range f.feeds {
+		if blocked, rule := feed.blockedBy(parsed, now); blocked {
+			return Decision{Reason: ReasonBlocklist, Rule: rule}
+		}
+	}
+	return f.defaultDecision()
+}
+
+//lookupAccess active entry of the most specific network containing ip
+func (f *Filter) lookupAccess(ip net.IP, now time.Time) (accessEntry, bool) {
+	value, ok := f.accessIPs.lookupFunc(ip, func(value interface{}) bool {
+		_, ok := value.(accessEntries).active(now)
+		return ok
+	})
+	if !ok {
+		return accessEntry{}, false
 	}
-	return f.defaultAllowed
+	return value.(accessEntries).active(now)
+}
+
+func (f *Filter) defaultDecision() Decision {
+	if f.defaultAllowed {
+		return Decision{Allowed: true, Reason: ReasonDefault}
+	}
+	return Decision{Reason: ReasonBlockedDefault, Rule: "blockedDefault"}
+}
+
+//geoAllowed whether ip passes country and ASN filtering, ips explicitly allowed are not filtered
+func (f *Filter) geoAllowed(ip string, now time.Time) (bool, string) {
+	parsed := net.ParseIP(normalizeIP(ip))
+	if f.geo == nil || parsed == nil {
+		return true, ""
+	}
+	if entry, ok := f.lookupAccess(parsed, now); ok && entry.allowed {
+		return true, ""
+	}
+	return f.geo.allowed(parsed)
 }
 
 // func (f *IPFilter) Blocked(ip string) bool {
@@ -172,14 +448,65 @@ func (f *Filter) Authorized(ip string, param string) bool {
 	if len(param) == 0 {
 		return false
 	}
+	return f.authorizedIPs.authorized(net.ParseIP(normalizeIP(ip)), param, time.Now())
+}
 
-	if ips, ok := f.authorizedIPs[param]; ok {
-		for _, val := range ips {
-			if ip == val {
-				return true
-			}
+//matchPath first protected path matched url path
+func (f *Filter) matchPath(urlPath string) (*pathFilter, bool) {
+	for _, p := range f.paths {
+		if p.match(urlPath) {
+			return p, true
 		}
 	}
+	return nil, false
+}
+
+//grant credential of request authorizing param instead of ip: verified api key in replace mode
+//or authorized client certificate. empty if none
+func (f *Filter) grant(c *gin.Context, param string) string {
+	if apiKeyVerified(c, param) && f.apiKeys.replaces(param) {
+		return "api key of resource " + param
+	}
+	if id := f.certs.authorized(clientCert(c), param); len(id) > 0 {
+		return "client certificate " + id
+	}
+	return ""
+}
+
+//decide full decision of ip filter for request r on protected path p. the first matched policy decides
+//after access entries and geoip, ip authorization is skipped when request has grant of resource
+func (f *Filter) decide(p *pathFilter, r *filterRequest, grant string) Decision {
+	ip, param, now := r.ip, r.cid, r.now
+	decision := f.access(ip, now)
+	if !decision.Allowed {
+		return decision
+	}
+	if ok, reason := f.geoAllowed(ip, now); !ok {
+		return Decision{Reason: ReasonGeoIP, Rule: reason}
+	}
+	if policy, ok := f.policies.match(r); ok {
+		return policy.decision()
+	}
+	if len(param) == 0 {
+		return Decision{Reason: ReasonUnauthorized, Rule: "missing resource"}
+	}
+	if len(grant) > 0 {
+		return Decision{Allowed: true, Reason: ReasonAuthorized, Rule: grant}
+	}
+	if !f.authorizedPath(p, ip, param, now) {
+		return Decision{Reason: ReasonUnauthorized, Rule: "resource " + param}
+	}
+	return Decision{Allowed: true, Reason: ReasonAuthorized, Rule: "resource " + param}
+}
 
-	return false
+//authorizedPath whether ip authorized for param by authorized table of path, global table is used if path has none
+func (f *Filter) authorizedPath(p *pathFilter, ip, param string, now time.Time) bool {
+	if len(param) == 0 {
+		return false
+	}
+	table := f.authorizedIPs
+	if p != nil && p.authorizedIPs != nil {
+		table = p.authorizedIPs
+	}
+	return table.authorized(net.ParseIP(normalizeIP(ip)), param, now)
 }
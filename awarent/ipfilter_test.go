@@ -0,0 +1,126 @@
+package awarent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestFilterCIDR(t *testing.T) {
+	origin := lookupIP
+	defer func() { lookupIP = origin }()
+	lookupIP = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return nil, errors.New("no such host")
+	}
+	f := New(FilterOptions{
+		AllowedIPs:     []string{"10.0.0.0/8", "10.1.2.3", "2001:db8::/32"},
+		BlockedIPs:     []string{"10.1.0.0/16", "192.168.1.24", "2001:db8:1::/48", "bad-entry"},
+		BlockByDefault: true,
+		AuthorizedIPs: []Authorized{
+			{Resource: "bigdata", IPS: []string{"172.16.0.0/12", "127.0.0.1"}},
+		},
+	})
+	cases := map[string]bool{
+		"10.9.9.9":        true,  // allowed /8
+		"10.1.9.9":        false, // blocked /16 more specific than allowed /8
+		"10.1.2.3":        true,  // allowed /32 more specific than blocked /16
+		"192.168.1.24":    false,
+		"8.8.8.8":         false, // blocked by default
+		"2001:db8::1":     true,
+		"2001:db8:1::1":   false,
+		"::ffff:10.9.9.9": true,
+		"":                false,
+		"not-ip":          false,
+	}
+	for ip, want := range cases {
+		if got := f.Allowed(ip); got != want {
+			t.Errorf("Allowed(%q) got %v, want %v", ip, got, want)
+		}
+	}
+	if !f.Authorized("172.20.1.1", "bigdata") || !f.Authorized("127.0.0.1", "bigdata") {
+		t.Errorf("authorized cidr should match")
+	}
+	if f.Authorized("172.32.0.1", "bigdata") || f.Authorized("172.20.1.1", "test") {
+		t.Errorf("unauthorized ip or resource should not match")
+	}
+}
+
+func TestFilterAllowedWinsSameNetwork(t *testing.T) {
+	f := New(FilterOptions{
+		AllowedIPs: []string{"10.0.0.0/8"},
+		BlockedIPs: []string{"10.0.0.0/8"},
+	})
+	if !f.Allowed("10.1.1.1") {
+		t.Errorf("allowed entry should win on the same network")
+	}
+}
+
+func TestFilterHostnames(t *testing.T) {
+	origin := lookupIP
+	defer func() { lookupIP = origin }()
+	lookupIP = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host == "localhost" {
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("::1")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	f := New(FilterOptions{
+		AllowedIPs:     []string{"localhost", "unknown.invalid", "10.0.0.0/33"},
+		BlockByDefault: true,
+		AuthorizedIPs:  []Authorized{{Resource: "test", IPS: []string{"localhost"}}},
+	})
+	if !f.Allowed("127.0.0.1") || !f.Allowed("::1") || !f.Authorized("127.0.0.1", "test") {
+		t.Errorf("hostname entry should be resolved")
+	}
+	if warnings := f.Warnings(); len(warnings) != 2 {
+		t.Errorf("expect 2 warnings, got %v", warnings)
+	}
+}
+
+func TestNormalizeIP(t *testing.T) {
+	cases := map[string]string{
+		"::ffff:1.2.3.4": "1.2.3.4",
+		"1.2.3.4":        "1.2.3.4",
+		"fe80::1%eth0":   "fe80::1",
+		"2001:DB8::1":    "2001:db8::1",
+		"localhost":      "",
+	}
+	for ip, want := range cases {
+		if got := normalizeIP(ip); got != want {
+			t.Errorf("normalizeIP(%q) got %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestAuthorizedGroups(t *testing.T) {
+	f := New(FilterOptions{
+		Groups: map[string][]string{
+			"monitoring": {"10.8.0.0/24", "10.9.0.1"},
+			"office":     {"192.0.2.0/24"},
+		},
+		AuthorizedIPs: []Authorized{
+			{Resource: "*", Groups: []string{"monitoring"}},
+			{Resource: "bigdata", IPS: []string{"198.51.100.1"}, Groups: []string{"office", "undefined"}},
+		},
+	})
+	cases := []struct {
+		ip, param string
+		want      bool
+	}{
+		{"10.8.0.5", "bigdata", true},
+		{"10.9.0.1", "anycid", true},
+		{"192.0.2.7", "bigdata", true},
+		{"198.51.100.1", "bigdata", true},
+		{"192.0.2.7", "other", false},
+		{"10.8.0.5", "", false},
+	}
+	for _, c := range cases {
+		if got := f.Authorized(c.ip, c.param); got != c.want {
+			t.Errorf("ip %s param %q got %v, want %v", c.ip, c.param, got, c.want)
+		}
+	}
+	if len(f.Warnings()) != 1 {
+		t.Errorf("undefined group should be warned, got %v", f.Warnings())
+	}
+}
@@ -0,0 +1,87 @@
+package awarent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//requestLimits body size and method limits of request with resource cid, limits of resource option
+//override limits of the matched route. zero size and empty methods mean no limit
+func (s *ruleSnapshot) requestLimits(c *gin.Context, cid string) (int64, []string) {
+	route, _ := s.rule.matchRoute(c)
+	return s.routeLimits(route, cid)
+}
+
+//routeLimits body size and method limits of resource cid on route, nil route for requests under urlPath
+func (s *ruleSnapshot) routeLimits(route *RouteRule, cid string) (int64, []string) {
+	var maxBody int64
+	var methods []string
+	if route != nil {
+		maxBody, methods = route.MaxBodyBytes, route.AllowedMethods
+	}
+	if opt, ok := s.rule.option(route, cid); ok {
+		if opt.MaxBodyBytes > 0 {
+			maxBody = opt.MaxBodyBytes
+		}
+		if len(opt.AllowedMethods) > 0 {
+			methods = opt.AllowedMethods
+		}
+	}
+	return maxBody, methods
+}
+
+func methodAllowed(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if m == anyMethod || strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+//checkRequestLimits apply method and body size limits to request under flow control, false returned if request is rejected.
+//request with larger Content-Length is rejected with 413, body without length is read up to the limit before handlers
+func (a *Awarent) checkRequestLimits(c *gin.Context) bool {
+	s := a.requestSnapshot(c)
+	if !s.flowProtected(c) {
+		return true
+	}
+	cid := s.flowResource(c)
+	maxBody, methods := s.requestLimits(c, cid)
+	status := 0
+	switch {
+	case !methodAllowed(methods, c.Request.Method):
+		c.Header("Allow", strings.ToUpper(strings.Join(methods, ", ")))
+		status = http.StatusMethodNotAllowed
+	case maxBody > 0 && c.Request.ContentLength > maxBody:
+		status = http.StatusRequestEntityTooLarge
+	case maxBody > 0 && c.Request.ContentLength < 0 && c.Request.Body != nil && c.Request.Body != http.NoBody:
+		body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+		c.Request.Body.Close()
+		switch {
+		case err != nil:
+			status = http.StatusBadRequest
+		case int64(len(body)) > maxBody:
+			status = http.StatusRequestEntityTooLarge
+		default:
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return true
+		}
+	default:
+		return true
+	}
+	c.AbortWithStatus(status)
+	lvs := []string{fmt.Sprintf("%d", status), c.Request.URL.Path, s.resourceName(c, cid)}
+	blockCount.WithLabelValues(lvs...).Inc()
+	reqCount.WithLabelValues(lvs...).Inc()
+	return false
+}
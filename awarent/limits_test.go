@@ -0,0 +1,66 @@
+package awarent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		Routes: []RouteRule{{
+			Path:           "/batch",
+			MaxBodyBytes:   8,
+			AllowedMethods: []string{"post"},
+			FlowControlRules: []FlowControlOption{
+				{Resource: "bigdata", Threshold: 100, MaxBodyBytes: 16},
+				{Resource: "report", Threshold: 100, AllowedMethods: []string{"GET", "POST"}},
+			},
+		}},
+	})
+	e := gin.New()
+	e.Use(a.Sentinel())
+	handler := func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	}
+	e.POST("/batch", handler)
+	e.GET("/batch", handler)
+	cases := []struct {
+		method, cid, body string
+		chunked           bool
+		want              int
+	}{
+		{http.MethodPost, "other", "12345678", false, http.StatusOK},
+		{http.MethodPost, "other", "123456789", false, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "other", "123456789", true, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "other", "1234", true, http.StatusOK},
+		{http.MethodPost, "bigdata", "123456789", false, http.StatusOK},
+		{http.MethodGet, "other", "", false, http.StatusMethodNotAllowed},
+		{http.MethodGet, "report", "", false, http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/batch?cid="+c.cid, strings.NewReader(c.body))
+		if c.chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s %s with %d bytes got %d, want %d", c.method, c.cid, len(c.body), w.Code, c.want)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != c.body {
+			t.Errorf("%s %s got body %q, want %q", c.method, c.cid, w.Body.String(), c.body)
+		}
+		if w.Code == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "POST" {
+			t.Errorf("Allow header %q, want POST", w.Header().Get("Allow"))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package awarent
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//MaintenanceOptions maintenance mode, requests are rejected with 503 and Message while Enabled.
+//Resources(cid) in maintenance, the whole service(paths protected by flow control or ip filter) if empty.
+//Retry-After header is set to RetryAfter seconds when configured, clients from AllowedIPs(ip or cidr) are served as usual
+type MaintenanceOptions struct {
+	Enabled    bool          `yaml:"enabled"`
+	Resources  []string      `yaml:"resources"`
+	Message    string        `yaml:"message"`
+	RetryAfter time.Duration `yaml:"retryAfter"`
+	AllowedIPs []string      `yaml:"allowedIPs"`
+}
+
+type maintenance struct {
+	resources  map[string]bool
+	message    string
+	retryAfter string
+	allowed    *ipTrie
+}
+
+//newMaintenance nil returned when maintenance mode is disabled
+func newMaintenance(opts MaintenanceOptions) *maintenance {
+	if !opts.Enabled {
+		return nil
+	}
+	m := &maintenance{message: opts.Message, allowed: newIPTrie()}
+	if len(opts.Resources) > 0 {
+		m.resources = map[string]bool{}
+		for _, resource := range opts.Resources {
+			m.resources[resource] = true
+		}
+	}
+	if opts.RetryAfter > 0 {
+		m.retryAfter = fmt.Sprintf("%d", int64(math.Ceil(opts.RetryAfter.Seconds())))
+	}
+	for _, entry := range opts.AllowedIPs {
+		if !m.allowed.insertEntry(entry, true, true) {
+			log.Printf("maintenance allowed entry ignored:%q is not ip or cidr\n", entry)
+		}
+	}
+	return m
+}
+
+//target resource of request under maintenance, false returned if request is not in maintenance
+func (s *ruleSnapshot) maintenanceTarget(c *gin.Context) (string, bool) {
+	m := s.maintenance
+	if m == nil {
+		return "", false
+	}
+	flow := s.flowProtected(c)
+	_, ipTarget, ip := s.ipTarget(c)
+	if !flow && !ip {
+		return "", false
+	}
+	cid := ipTarget
+	if flow {
+		cid = s.flowResource(c)
+	}
+	if m.resources == nil {
+		return cid, true
+	}
+	return cid, m.resources[cid] || m.resources[ipTarget]
+}
+
+//Maintenance maintenance mode middleware following current rule, use it before other awarent middlewares.
+//requests in maintenance are rejected with 503 and counted as blocked
+func (a *Awarent) Maintenance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := a.requestSnapshot(c)
+		cid, ok := s.maintenanceTarget(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		m := s.maintenance
+		if ip := net.ParseIP(a.clientIP(c)); ip != nil && m.allowed.contains(ip) {
+			c.Next()
+			return
+		}
+		if len(m.retryAfter) > 0 {
+			c.Header("Retry-After", m.retryAfter)
+		}
+		if len(m.message) > 0 {
+			c.String(http.StatusServiceUnavailable, m.message)
+			c.Abort()
+		} else {
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+		}
+		lvs := []string{fmt.Sprintf("%d", http.StatusServiceUnavailable), c.Request.URL.Path, s.resourceName(c, cid)}
+		blockCount.WithLabelValues(lvs...).Inc()
+		reqCount.WithLabelValues(lvs...).Inc()
+	}
+}
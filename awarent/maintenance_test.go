@@ -0,0 +1,70 @@
+package awarent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaintenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rule := Rule{
+		ResourceParam: "cid",
+		IPFilterRules: FilterOptions{URLPath: "/q", URLParam: "cid"},
+		Maintenance: MaintenanceOptions{
+			Enabled:    true,
+			Resources:  []string{"backfill"},
+			Message:    "under maintenance",
+			RetryAfter: 90 * time.Second,
+			AllowedIPs: []string{"10.0.0.0/8"},
+		},
+	}
+	a := &Awarent{}
+	a.setRule(rule)
+	e := gin.New()
+	e.Use(a.Maintenance())
+	e.GET("/q", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	e.GET("/health", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	serve := func(url, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.RemoteAddr = ip + ":1"
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		return w
+	}
+	w := serve("/q?cid=backfill", "192.0.2.1")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "90" || w.Body.String() != "under maintenance" {
+		t.Errorf("resource in maintenance got %d %q %q", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+	cases := []struct {
+		url, ip string
+		want    int
+	}{
+		{"/q?cid=other", "192.0.2.1", http.StatusOK},
+		{"/q?cid=backfill", "10.1.2.3", http.StatusOK},
+		{"/health", "192.0.2.1", http.StatusOK},
+	}
+	for _, c := range cases {
+		if got := serve(c.url, c.ip).Code; got != c.want {
+			t.Errorf("%s from %s got %d, want %d", c.url, c.ip, got, c.want)
+		}
+	}
+
+	rule.Maintenance.Resources = nil
+	a.setRule(rule)
+	if got := serve("/q?cid=other", "192.0.2.1").Code; got != http.StatusServiceUnavailable {
+		t.Errorf("whole service maintenance got %d, want 503", got)
+	}
+	if got := serve("/health", "192.0.2.1").Code; got != http.StatusOK {
+		t.Errorf("unprotected path got %d, want 200", got)
+	}
+
+	rule.Maintenance.Enabled = false
+	a.setRule(rule)
+	if got := serve("/q?cid=backfill", "192.0.2.1").Code; got != http.StatusOK {
+		t.Errorf("disabled maintenance got %d, want 200", got)
+	}
+}
@@ -18,6 +18,7 @@ type (
 	options struct {
 		paramExtractor  func(*gin.Context) bool
 		blockExtractor  func(*gin.Context) bool
+		bypassExtractor func(*gin.Context) bool
 		resourceExtract func(*gin.Context) string
 		blockFallback   func(*gin.Context)
 	}
@@ -44,6 +45,13 @@ func WithBlockExtractor(fn func(*gin.Context) bool) Option {
 	}
 }
 
+// WithBypassExtractor sets the extractor of requests passing without flow control and quota accounting.
+func WithBypassExtractor(fn func(*gin.Context) bool) Option {
+	return func(opts *options) {
+		opts.bypassExtractor = fn
+	}
+}
+
 // WithResourceExtractor sets the resource extractor of the web requests.
 func WithResourceExtractor(fn func(*gin.Context) string) Option {
 	return func(opts *options) {
@@ -78,6 +86,17 @@ func SentinelMiddleware(opts ...Option) gin.HandlerFunc {
 			resourceName = options.resourceExtract(c)
 		}
 
+		if options.bypassExtractor != nil && options.bypassExtractor(c) {
+			c.Next()
+			status := fmt.Sprintf("%d", c.Writer.Status())
+			endpoint := c.Request.URL.Path
+			lvs := []string{status, endpoint, resourceName}
+			bypassCount.WithLabelValues(lvs...).Inc()
+			reqCount.WithLabelValues(lvs...).Inc()
+			reqDuration.WithLabelValues(lvs...).Observe(time.Since(start).Seconds())
+			return
+		}
+
 		entry, err := sentinel.Entry(
 			resourceName,
 			sentinel.WithResourceType(base.ResTypeWeb),
@@ -153,6 +172,31 @@ var (
 		Name:      "http_block_total",
 		Help:      "Total number of HTTP requests blocked.",
 	}, labels)
+	bypassCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_bypassed_total",
+		Help:      "Total number of HTTP requests bypassed flow control.",
+	}, labels)
+	banCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ip_ban_total",
+		Help:      "Total number of ips banned automatically.",
+	}, []string{"rule"})
+	decisionCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ip_filter_decision_total",
+		Help:      "Total number of ip filter decisions by reason.",
+	}, []string{"decision", "reason"})
+	faultCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fault_injected_total",
+		Help:      "Total number of faults injected by kind.",
+	}, []string{"fault", "resource"})
+	bannedIPs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "banned_ips",
+		Help:      "Number of ips banned currently.",
+	})
 	reqDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
@@ -168,7 +212,7 @@ var promHandler http.Handler
 // init registers the prometheus metrics
 func init() {
 	promRegistry := prometheus.NewRegistry()
-	promRegistry.MustRegister(uptime, reqCount, passCount, blockCount, reqDuration)
+	promRegistry.MustRegister(uptime, reqCount, passCount, blockCount, bypassCount, banCount, decisionCount, faultCount, bannedIPs, reqDuration)
 	go recordUptime()
 	promHandler = promhttp.InstrumentMetricHandler(promRegistry, promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{}))
 }
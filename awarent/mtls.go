@@ -0,0 +1,134 @@
+package awarent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//AuthorizedCert client certificate identities authorized for resource, instead of ip. Subjects match common name
+//or full distinguished name of the verified client certificate, SANs match its dns names, emails, uris and ips.
+//dns SAN such as *.example.com matches one label
+type AuthorizedCert struct {
+	Resource string   `yaml:"resource"`
+	Subjects []string `yaml:"subjects"`
+	SANs     []string `yaml:"sans"`
+}
+
+//TLSOptions tls server options, client certificates are verified with ClientCAFile bundle when configured.
+//ClientAuth is optional(default) or require
+type TLSOptions struct {
+	CertFile     string `yaml:"certFile" toml:"certFile" json:"certFile"`
+	KeyFile      string `yaml:"keyFile" toml:"keyFile" json:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile" toml:"clientCAFile" json:"clientCAFile"`
+	ClientAuth   string `yaml:"clientAuth" toml:"clientAuth" json:"clientAuth"`
+}
+
+//NewTLSConfig build tls server config, set it as http.Server.TLSConfig
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(opts.ClientCAFile) == 0 {
+		return config, nil
+	}
+	pem, err := ioutil.ReadFile(opts.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client ca: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in client ca %s", opts.ClientCAFile)
+	}
+	config.ClientCAs = pool
+	switch strings.ToLower(opts.ClientAuth) {
+	case "", "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client auth %q", opts.ClientAuth)
+	}
+	return config, nil
+}
+
+type certIdentities struct {
+	subjects map[string]bool
+	sans     map[string]bool
+}
+
+//certTable authorized certificate identities of resource
+type certTable map[string]*certIdentities
+
+func newCertTable(entries []AuthorizedCert) certTable {
+	table := certTable{}
+	for _, entry := range entries {
+		if len(entry.Resource) == 0 {
+			continue
+		}
+		ids := table[entry.Resource]
+		if ids == nil {
+			ids = &certIdentities{subjects: map[string]bool{}, sans: map[string]bool{}}
+			table[entry.Resource] = ids
+		}
+		for _, subject := range entry.Subjects {
+			ids.subjects[strings.TrimSpace(subject)] = true
+		}
+		for _, san := range entry.SANs {
+			ids.sans[strings.ToLower(strings.TrimSpace(san))] = true
+		}
+	}
+	return table
+}
+
+//authorized matched identity of certificate authorized for resource, empty if not authorized
+func (t certTable) authorized(cert *x509.Certificate, resource string) string {
+	ids, ok := t[resource]
+	if !ok || cert == nil {
+		return ""
+	}
+	for _, subject := range []string{cert.Subject.CommonName, cert.Subject.String()} {
+		if len(subject) > 0 && ids.subjects[subject] {
+			return "subject " + subject
+		}
+	}
+	var sans []string
+	for _, name := range cert.DNSNames {
+		name = strings.ToLower(name)
+		sans = append(sans, name)
+		if idx := strings.IndexByte(name, '.'); idx > 0 {
+			sans = append(sans, "*"+name[idx:])
+		}
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		if ids.sans[strings.ToLower(san)] {
+			return "san " + san
+		}
+	}
+	return ""
+}
+
+//clientCert verified client certificate of request, nil if connection is not mTLS
+func clientCert(c *gin.Context) *x509.Certificate {
+	state := c.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
@@ -0,0 +1,115 @@
+package awarent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestCert(t *testing.T, template *x509.Certificate) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(1)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+func TestCertAuthorization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		IPFilterRules: FilterOptions{
+			URLPath:  "/q",
+			URLParam: "cid",
+			AuthorizedCerts: []AuthorizedCert{
+				{Resource: "internal", Subjects: []string{"billing"}},
+				{Resource: "mesh", SANs: []string{"*.svc.example.com"}},
+			},
+		},
+	})
+	e := gin.New()
+	e.Use(a.IPFilter())
+	e.GET("/q", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing", Organization: []string{"example"}},
+		DNSNames: []string{"billing.svc.example.com"},
+	}
+	cases := []struct {
+		url  string
+		cert *x509.Certificate
+		want int
+	}{
+		{"/q?cid=internal", cert, http.StatusOK},
+		{"/q?cid=mesh", cert, http.StatusOK},
+		{"/q?cid=other", cert, http.StatusForbidden},
+		{"/q?cid=internal", nil, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.url, nil)
+		if c.cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c.cert}}}
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s with cert %v got %d, want %d", c.url, c.cert != nil, w.Code, c.want)
+		}
+	}
+	//unverified peer certificate is ignored
+	req := httptest.NewRequest(http.MethodGet, "/q?cid=internal", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unverified certificate got %d, want 403", w.Code)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	der, key := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ca"}, IsCA: true, BasicConstraintsValid: true})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	config, err := NewTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile, ClientAuth: "require"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Errorf("client certificates should be required and verified")
+	}
+	if _, err := NewTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile}); err == nil {
+		t.Errorf("ca bundle without certificate should fail")
+	}
+}
@@ -0,0 +1,80 @@
+package awarent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//PathOptions protected url path of ip filter. Pattern is exact path such as /q, prefix ending with /** such as /active/**,
+//or glob pattern of path.Match such as /v*/batch. resource is extracted by ResourceExtractor of the path,
+//fallback to rule resource extractor. AuthorizedIPs of the path replace global authorized table when configured
+type PathOptions struct {
+	Pattern           string           `yaml:"pattern"`
+	ResourceExtractor ExtractorOptions `yaml:"resource-extractor"`
+	AuthorizedIPs     []Authorized     `yaml:"authorized"`
+}
+
+type pathFilter struct {
+	pattern       string
+	match         func(string) bool
+	extract       ResourceExtractor
+	authorizedIPs authorizedTable
+}
+
+func newPathFilter(opts PathOptions, resolver *entryResolver) (*pathFilter, error) {
+	match, err := newPathMatcher(opts.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	extract, err := NewResourceExtractor(opts.ResourceExtractor)
+	if err != nil {
+		return nil, fmt.Errorf("path %s: %v", opts.Pattern, err)
+	}
+	p := &pathFilter{
+		pattern: opts.Pattern,
+		match:   match,
+		extract: extract,
+	}
+	if len(opts.AuthorizedIPs) > 0 {
+		p.authorizedIPs = newAuthorizedTable(opts.AuthorizedIPs, resolver)
+	}
+	return p, nil
+}
+
+//newPathMatcher matcher of exact, prefix(/**) or glob path pattern
+func newPathMatcher(pattern string) (func(string) bool, error) {
+	switch {
+	case len(pattern) == 0:
+		return nil, fmt.Errorf("empty path pattern")
+	case strings.HasSuffix(pattern, "/**"):
+		prefix := strings.TrimSuffix(pattern, "/**")
+		return func(p string) bool { return protectedPath(prefix, p) }, nil
+	case strings.ContainsAny(pattern, "*?["):
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %s: %v", pattern, err)
+		}
+		return func(p string) bool {
+			ok, _ := path.Match(pattern, p)
+			return ok
+		}, nil
+	}
+	return func(p string) bool { return p == pattern }, nil
+}
+
+//resource resource of request, fallback extractor is used when path has no resource extractor.
+//resource extracted by extractor of the path is shared by middlewares of the request
+func (p *pathFilter) resource(c *gin.Context, fallback func(*gin.Context) string) string {
+	if p.extract == nil {
+		return fallback(c)
+	}
+	key := resourceKey + ":" + p.pattern
+	if resource, ok := c.Get(key); ok {
+		return resource.(string)
+	}
+	resource := p.extract(c)
+	c.Set(key, resource)
+	return resource
+}
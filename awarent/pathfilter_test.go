@@ -0,0 +1,76 @@
+package awarent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPathMatcher(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/q", "/q", true},
+		{"/q", "/q/1", false},
+		{"/active/**", "/active", true},
+		{"/active/**", "/active/bigdata/devices", true},
+		{"/active/**", "/activex", false},
+		{"/v*/batch", "/v1/batch", true},
+		{"/v*/batch", "/v1/x/batch", false},
+		{"/batch/?", "/batch/1", true},
+	}
+	for _, tc := range cases {
+		match, err := newPathMatcher(tc.pattern)
+		if err != nil {
+			t.Fatalf("pattern %s error:%v", tc.pattern, err)
+		}
+		if got := match(tc.path); got != tc.want {
+			t.Errorf("pattern %s path %s got %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+	for _, pattern := range []string{"", "/v[/batch"} {
+		if _, err := newPathMatcher(pattern); err == nil {
+			t.Errorf("pattern %q should be invalid", pattern)
+		}
+	}
+}
+
+func TestFilterPaths(t *testing.T) {
+	f := New(FilterOptions{
+		AuthorizedIPs: []Authorized{{Resource: "bigdata", IPS: []string{"192.0.2.1"}}},
+		Paths: []PathOptions{
+			{Pattern: "/q"},
+			{Pattern: "/batch/**", AuthorizedIPs: []Authorized{{Resource: "bigdata", IPS: []string{"192.0.2.2"}}}},
+		},
+	})
+	p, ok := f.matchPath("/q")
+	if !ok || !f.authorizedPath(p, "192.0.2.1", "bigdata", time.Now()) {
+		t.Errorf("global authorized table should apply to /q")
+	}
+	p, ok = f.matchPath("/batch/upload")
+	if !ok || f.authorizedPath(p, "192.0.2.1", "bigdata", time.Now()) || !f.authorizedPath(p, "192.0.2.2", "bigdata", time.Now()) {
+		t.Errorf("path authorized table should apply to /batch/upload")
+	}
+	if _, ok := f.matchPath("/other"); ok {
+		t.Errorf("/other should not be protected")
+	}
+}
+
+func TestInvalidPathRejected(t *testing.T) {
+	cases := []PathOptions{
+		{Pattern: "/v[/batch"},
+		{Pattern: "/batch/**", ResourceExtractor: ExtractorOptions{Sources: []string{"jwt:sub"}}},
+	}
+	for _, path := range cases {
+		opts := FilterOptions{Paths: []PathOptions{{Pattern: "/q"}, path}}
+		if err := New(opts).Err(); err == nil {
+			t.Errorf("path %q with invalid options should be an error", path.Pattern)
+		}
+		a := &Awarent{}
+		a.setRule(Rule{IPFilterRules: FilterOptions{Paths: []PathOptions{{Pattern: "/batch/**"}}}})
+		current := a.snapshot()
+		if err := a.setRule(Rule{IPFilterRules: opts}); err == nil || a.snapshot() != current {
+			t.Errorf("rule with invalid path %q should keep current snapshot", path.Pattern)
+		}
+	}
+}
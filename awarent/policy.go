@@ -0,0 +1,579 @@
+package awarent
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//actions of policy
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+//PolicyOptions expression policy evaluated by ip filter after allowed/blocked entries and geoip, before authorization.
+//the first policy whose When expression is true decides: allow authorizes the request, deny rejects it.
+//When is a boolean expression over variables ip, cid, path, method, weekday(mon..sun), time(HH:MM), hour and header("Name"),
+//with operators == != < <= > >= in matches ! && || and parentheses, such as
+//cid == "ads" && !(ip in ["10.2.0.0/16"] && weekday in ["mon", "tue", "wed", "thu", "fri"] && header("X-Env") == "prod").
+//time variables are in Timezone, IANA name such as Asia/Shanghai, local timezone by default
+type PolicyOptions struct {
+	Name     string `yaml:"name"`
+	When     string `yaml:"when"`
+	Action   string `yaml:"action"`
+	Timezone string `yaml:"timezone"`
+}
+
+//filterRequest attributes of request evaluated by ip filter
+type filterRequest struct {
+	ip     string
+	cid    string
+	path   string
+	method string
+	header http.Header
+	now    time.Time
+}
+
+func newFilterRequest(c *gin.Context, ip, cid string, now time.Time) *filterRequest {
+	return &filterRequest{
+		ip:     ip,
+		cid:    cid,
+		path:   c.Request.URL.Path,
+		method: c.Request.Method,
+		header: c.Request.Header,
+		now:    now,
+	}
+}
+
+type policy struct {
+	name  string
+	allow bool
+	when  func(r *filterRequest) bool
+}
+
+type policyList []*policy
+
+//compilePolicies compile policies in order, errors of invalid policies are returned and fail the ip filter
+func compilePolicies(opts []PolicyOptions) (policyList, []error) {
+	var policies policyList
+	var errs []error
+	for i, opt := range opts {
+		name := opt.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		p, err := compilePolicy(name, opt)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("policy %s: %v", name, err))
+			continue
+		}
+		policies = append(policies, p)
+	}
+	return policies, errs
+}
+
+func compilePolicy(name string, opt PolicyOptions) (*policy, error) {
+	p := &policy{name: name}
+	switch strings.ToLower(opt.Action) {
+	case PolicyAllow:
+		p.allow = true
+	case PolicyDeny:
+	default:
+		return nil, fmt.Errorf("invalid action %q, allow or deny expected", opt.Action)
+	}
+	loc := time.Local
+	if len(opt.Timezone) > 0 {
+		var err error
+		if loc, err = time.LoadLocation(opt.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q", opt.Timezone)
+		}
+	}
+	when, err := compileExpr(opt.When, loc)
+	if err != nil {
+		return nil, err
+	}
+	p.when = when
+	return p, nil
+}
+
+//match the first policy whose expression is true
+func (policies policyList) match(r *filterRequest) (*policy, bool) {
+	for _, p := range policies {
+		if p.when(r) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (p *policy) decision() Decision {
+	return Decision{Allowed: p.allow, Reason: ReasonPolicy, Rule: "policy " + p.name}
+}
+
+//exprError invalid expression error with 1-based column
+type exprError struct {
+	col int
+	msg string
+}
+
+func (e *exprError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.col, e.msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+//lexExpr split expression into tokens, strings are double quoted with go escapes or single quoted as is
+func lexExpr(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			start := i
+			for i < len(expr) && (expr[i] == '_' || expr[i] >= 'a' && expr[i] <= 'z' || expr[i] >= 'A' && expr[i] <= 'Z' || expr[i] >= '0' && expr[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, expr[start:i], start})
+		case ch >= '0' && ch <= '9':
+			start := i
+			for i < len(expr) && (expr[i] >= '0' && expr[i] <= '9' || expr[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, expr[start:i], start})
+		case ch == '"' || ch == '\'':
+			start := i
+			i++
+			for i < len(expr) && expr[i] != ch {
+				if ch == '"' && expr[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(expr) {
+				return nil, &exprError{start + 1, "unterminated string"}
+			}
+			i++
+			text := expr[start+1 : i-1]
+			if ch == '"' {
+				unquoted, err := strconv.Unquote(expr[start:i])
+				if err != nil {
+					return nil, &exprError{start + 1, "invalid string " + expr[start:i]}
+				}
+				text = unquoted
+			}
+			tokens = append(tokens, token{tokString, text, start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if len(op) == 0 {
+				return nil, &exprError{i + 1, fmt.Sprintf("unexpected character %q", ch)}
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(expr)}), nil
+}
+
+type valueType int
+
+const (
+	typeBool valueType = iota
+	typeString
+	typeNumber
+	typeIP
+	typeList
+)
+
+var typeNames = map[valueType]string{
+	typeBool: "bool", typeString: "string", typeNumber: "number", typeIP: "ip", typeList: "list",
+}
+
+//operand compiled operand, literal is set for constants. list is always literal
+type operand struct {
+	typ     valueType
+	pos     int
+	literal interface{}
+	eval    func(r *filterRequest) interface{}
+}
+
+func literalOperand(typ valueType, pos int, val interface{}) *operand {
+	return &operand{typ: typ, pos: pos, literal: val, eval: func(*filterRequest) interface{} { return val }}
+}
+
+type exprParser struct {
+	tokens []token
+	next   int
+	loc    *time.Location
+}
+
+//compileExpr compile boolean expression of policy, times are evaluated in loc
+func compileExpr(expr string, loc *time.Location) (func(r *filterRequest) bool, error) {
+	if len(strings.TrimSpace(expr)) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	tokens, err := lexExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, loc: loc}
+	when, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &exprError{t.pos + 1, "unexpected " + t.String()}
+	}
+	return when, nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return &exprError{t.pos + 1, fmt.Sprintf("expected %q, found %s", op, t)}
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (func(r *filterRequest) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r *filterRequest) bool { return l(r) || right(r) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (func(r *filterRequest) bool, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r *filterRequest) bool { return l(r) && right(r) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (func(r *filterRequest) bool, error) {
+	if p.accept("!") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(r *filterRequest) bool { return !inner(r) }, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func (p *exprParser) parseComparison() (func(r *filterRequest) bool, error) {
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	var op string
+	switch {
+	case t.kind == tokOp && comparisonOps[t.text]:
+		op = t.text
+	case t.kind == tokIdent && (t.text == "in" || t.text == "matches"):
+		op = t.text
+	default:
+		if left.typ != typeBool {
+			return nil, &exprError{left.pos + 1, fmt.Sprintf("%s value is not a condition, comparison expected", typeNames[left.typ])}
+		}
+		return func(r *filterRequest) bool { return left.eval(r).(bool) }, nil
+	}
+	p.take()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return compileComparison(op, t.pos, left, right)
+}
+
+func (p *exprParser) parseOperand() (*operand, error) {
+	t := p.take()
+	switch t.kind {
+	case tokString:
+		return literalOperand(typeString, t.pos, t.text), nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, &exprError{t.pos + 1, "invalid number " + t.text}
+		}
+		return literalOperand(typeNumber, t.pos, n), nil
+	case tokIdent:
+		return p.parseVariable(t)
+	case tokOp:
+		if t.text == "[" {
+			return p.parseList(t)
+		}
+	}
+	return nil, &exprError{t.pos + 1, "expected operand, found " + t.String()}
+}
+
+//parseList list literal of strings or numbers
+func (p *exprParser) parseList(open token) (*operand, error) {
+	var strs []string
+	var nums []float64
+	for !p.accept("]") {
+		if len(strs)+len(nums) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		item, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case item.literal == nil || item.typ == typeList || item.typ == typeBool:
+			return nil, &exprError{item.pos + 1, "list item must be string or number literal"}
+		case item.typ == typeString && len(nums) == 0:
+			strs = append(strs, item.literal.(string))
+		case item.typ == typeNumber && len(strs) == 0:
+			nums = append(nums, item.literal.(float64))
+		default:
+			return nil, &exprError{item.pos + 1, "list items must be of the same type"}
+		}
+	}
+	if nums != nil {
+		return literalOperand(typeList, open.pos, nums), nil
+	}
+	return literalOperand(typeList, open.pos, strs), nil
+}
+
+func (p *exprParser) parseVariable(t token) (*operand, error) {
+	loc := p.loc
+	variable := func(typ valueType, eval func(r *filterRequest) interface{}) (*operand, error) {
+		return &operand{typ: typ, pos: t.pos, eval: eval}, nil
+	}
+	switch t.text {
+	case "true", "false":
+		return literalOperand(typeBool, t.pos, t.text == "true"), nil
+	case "ip":
+		return variable(typeIP, func(r *filterRequest) interface{} { return net.ParseIP(r.ip) })
+	case "cid":
+		return variable(typeString, func(r *filterRequest) interface{} { return r.cid })
+	case "path":
+		return variable(typeString, func(r *filterRequest) interface{} { return r.path })
+	case "method":
+		return variable(typeString, func(r *filterRequest) interface{} { return r.method })
+	case "weekday":
+		return variable(typeString, func(r *filterRequest) interface{} {
+			return strings.ToLower(r.now.In(loc).Weekday().String()[:3])
+		})
+	case "time":
+		return variable(typeString, func(r *filterRequest) interface{} { return r.now.In(loc).Format("15:04") })
+	case "hour":
+		return variable(typeNumber, func(r *filterRequest) interface{} { return float64(r.now.In(loc).Hour()) })
+	case "header":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		name := p.take()
+		if name.kind != tokString {
+			return nil, &exprError{name.pos + 1, "header name must be string literal, found " + name.String()}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return variable(typeString, func(r *filterRequest) interface{} { return r.header.Get(name.text) })
+	}
+	return nil, &exprError{t.pos + 1, fmt.Sprintf("unknown variable %q", t.text)}
+}
+
+func compileComparison(op string, pos int, left, right *operand) (func(r *filterRequest) bool, error) {
+	mismatch := func() error {
+		return &exprError{pos + 1, fmt.Sprintf("operator %s can not compare %s with %s", op, typeNames[left.typ], typeNames[right.typ])}
+	}
+	switch op {
+	case "in":
+		return compileIn(pos, left, right, mismatch)
+	case "matches":
+		pattern, ok := right.literal.(string)
+		if left.typ != typeString || !ok {
+			return nil, &exprError{pos + 1, "matches requires string on the left and regexp string literal on the right"}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, &exprError{right.pos + 1, fmt.Sprintf("invalid regexp %q: %v", pattern, err)}
+		}
+		return func(r *filterRequest) bool { return re.MatchString(left.eval(r).(string)) }, nil
+	}
+	if left.typ == typeIP || right.typ == typeIP {
+		return compileIPEqual(op, pos, left, right, mismatch)
+	}
+	if left.typ != right.typ || left.typ == typeList {
+		return nil, mismatch()
+	}
+	if op == "==" || op == "!=" {
+		equal := op == "=="
+		return func(r *filterRequest) bool { return (left.eval(r) == right.eval(r)) == equal }, nil
+	}
+	if left.typ == typeBool {
+		return nil, mismatch()
+	}
+	return func(r *filterRequest) bool {
+		var cmp int
+		switch l := left.eval(r).(type) {
+		case string:
+			cmp = strings.Compare(l, right.eval(r).(string))
+		case float64:
+			if rv := right.eval(r).(float64); l < rv {
+				cmp = -1
+			} else if l > rv {
+				cmp = 1
+			}
+		}
+		switch op {
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		}
+		return cmp >= 0
+	}, nil
+}
+
+//compileIPEqual ip compared with ip literal
+func compileIPEqual(op string, pos int, left, right *operand, mismatch func() error) (func(r *filterRequest) bool, error) {
+	if right.typ == typeIP {
+		left, right = right, left
+	}
+	literal, ok := right.literal.(string)
+	if !ok || (op != "==" && op != "!=") {
+		return nil, mismatch()
+	}
+	ip := net.ParseIP(literal)
+	if ip == nil {
+		return nil, &exprError{right.pos + 1, fmt.Sprintf("%q is not ip", literal)}
+	}
+	equal := op == "=="
+	return func(r *filterRequest) bool { return ip.Equal(left.eval(r).(net.IP)) == equal }, nil
+}
+
+//compileIn membership of string or number in list, or ip in ip/cidr string or list
+func compileIn(pos int, left, right *operand, mismatch func() error) (func(r *filterRequest) bool, error) {
+	if left.typ == typeIP {
+		var entries []string
+		switch literal := right.literal.(type) {
+		case string:
+			entries = []string{literal}
+		case []string:
+			entries = literal
+		default:
+			return nil, mismatch()
+		}
+		networks := newIPTrie()
+		for _, entry := range entries {
+			if !networks.insertEntry(entry, true, true) {
+				return nil, &exprError{right.pos + 1, fmt.Sprintf("%q is not ip or cidr", entry)}
+			}
+		}
+		return func(r *filterRequest) bool {
+			ip, _ := left.eval(r).(net.IP)
+			return ip != nil && networks.contains(ip)
+		}, nil
+	}
+	switch list := right.literal.(type) {
+	case []string:
+		if left.typ != typeString {
+			return nil, mismatch()
+		}
+		set := map[string]bool{}
+		for _, item := range list {
+			set[item] = true
+		}
+		return func(r *filterRequest) bool { return set[left.eval(r).(string)] }, nil
+	case []float64:
+		if left.typ != typeNumber {
+			return nil, mismatch()
+		}
+		set := map[float64]bool{}
+		for _, item := range list {
+			set[item] = true
+		}
+		return func(r *filterRequest) bool { return set[left.eval(r).(float64)] }, nil
+	}
+	return nil, &exprError{pos + 1, "in requires list literal on the right"}
+}
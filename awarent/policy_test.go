@@ -0,0 +1,104 @@
+package awarent
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyExpression(t *testing.T) {
+	//2021-03-01 is monday
+	monday := time.Date(2021, 3, 1, 10, 30, 0, 0, time.UTC)
+	header := http.Header{}
+	header.Set("X-Env", "prod")
+	cases := []struct {
+		expr string
+		r    filterRequest
+		want bool
+	}{
+		{`cid == "ads"`, filterRequest{cid: "ads"}, true},
+		{`cid != 'ads'`, filterRequest{cid: "ads"}, false},
+		{`ip in "10.2.0.0/16"`, filterRequest{ip: "10.2.3.4"}, true},
+		{`ip in ["10.2.0.0/16", "192.0.2.1"]`, filterRequest{ip: "192.0.2.1"}, true},
+		{`ip in ["10.2.0.0/16"]`, filterRequest{ip: "10.3.0.1"}, false},
+		{`ip == "192.0.2.1"`, filterRequest{ip: "192.0.2.1"}, true},
+		{`method in ["GET", "HEAD"] && path matches "^/api/"`, filterRequest{method: "GET", path: "/api/q"}, true},
+		{`weekday in ["sat", "sun"]`, filterRequest{now: monday}, false},
+		{`time >= "09:00" && time < "18:00"`, filterRequest{now: monday}, true},
+		{`hour >= 12 || header("X-Env") == "prod"`, filterRequest{now: monday, header: header}, true},
+		{`header("X-Env") == "prod"`, filterRequest{now: monday}, false},
+		{`!(cid == "ads") || false`, filterRequest{cid: "ads"}, false},
+		{`cid == "ads" && !(ip in ["10.2.0.0/16"] && weekday in ["mon", "tue", "wed", "thu", "fri"] && header("X-Env") == "prod")`,
+			filterRequest{cid: "ads", ip: "10.2.0.1", now: monday, header: header}, false},
+		{`cid == "ads" && !(ip in ["10.2.0.0/16"] && weekday in ["mon", "tue", "wed", "thu", "fri"] && header("X-Env") == "prod")`,
+			filterRequest{cid: "ads", ip: "10.9.0.1", now: monday, header: header}, true},
+	}
+	for _, c := range cases {
+		when, err := compileExpr(c.expr, time.UTC)
+		if err != nil {
+			t.Errorf("compile %s: %v", c.expr, err)
+			continue
+		}
+		r := c.r
+		if got := when(&r); got != c.want {
+			t.Errorf("%s = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestPolicyErrors(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{``, "empty expression"},
+		{`cid ==`, "column 7: expected operand, found end of expression"},
+		{`cid = "ads"`, `column 5: unexpected character '='`},
+		{`user == "x"`, `column 1: unknown variable "user"`},
+		{`hour == "9"`, "column 6: operator == can not compare number with string"},
+		{`ip in ["10.2.0.0/33"]`, `column 7: "10.2.0.0/33" is not ip or cidr`},
+		{`path matches "("`, "column 14: invalid regexp"},
+		{`cid`, "column 1: string value is not a condition"},
+		{`(cid == "a"`, `column 12: expected ")", found end of expression`},
+		{`cid in ["a", 1]`, "column 14: list items must be of the same type"},
+		{`cid == "a" cid`, `column 12: unexpected "cid"`},
+		{`cid == "a`, "column 8: unterminated string"},
+	}
+	for _, c := range cases {
+		_, err := compileExpr(c.expr, time.UTC)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("compile %s got error %v, want %s", c.expr, err, c.want)
+		}
+	}
+}
+
+func TestPolicyDecision(t *testing.T) {
+	f := New(FilterOptions{
+		BlockedIPs:    []string{"198.51.100.1"},
+		AuthorizedIPs: []Authorized{{Resource: "ads", IPS: []string{"192.0.2.1"}}},
+		Policies: []PolicyOptions{
+			{Name: "ads-office", When: `cid == "ads" && !(ip in ["10.2.0.0/16"])`, Action: "deny"},
+			{Name: "office", When: `ip in "10.0.0.0/8"`, Action: "allow"},
+			{Name: "broken", When: `cid ==`, Action: "allow"},
+		},
+	})
+	if err := f.Err(); err == nil || !strings.Contains(err.Error(), "policy broken: column 7") {
+		t.Errorf("error %v, want invalid policy broken", err)
+	}
+	cases := []struct {
+		ip, cid string
+		want    Decision
+	}{
+		{"192.0.2.1", "ads", Decision{Reason: ReasonPolicy, Rule: "policy ads-office"}},
+		{"10.2.0.1", "ads", Decision{Allowed: true, Reason: ReasonPolicy, Rule: "policy office"}},
+		{"10.3.0.1", "", Decision{Allowed: true, Reason: ReasonPolicy, Rule: "policy office"}},
+		{"192.0.2.1", "other", Decision{Reason: ReasonUnauthorized, Rule: "resource other"}},
+		{"198.51.100.1", "ads", Decision{Reason: ReasonBlocked, Rule: "198.51.100.1"}},
+	}
+	for _, c := range cases {
+		if got := f.decide(nil, &filterRequest{ip: c.ip, cid: c.cid, now: time.Now()}, ""); got != c.want {
+			t.Errorf("decide %s %s = %+v, want %+v", c.ip, c.cid, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package awarent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//proxyHeaderTimeout max duration of reading PROXY protocol header
+const proxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader    = errors.New("invalid PROXY protocol header")
+	errListenerClosed = errors.New("use of closed network connection")
+)
+
+//NewProxyProtoListener wrap listener to accept connections with PROXY protocol v1/v2 header.
+//the header is optional, source address declared by header is only used for client ip
+//when the peer is a trusted proxy and proxy-protocol is in clientIPHeaders of ip filter rules.
+//use ConnContext as http.Server.ConnContext so that the source address is visible to middlewares.
+//the header is read before the connection is returned by Accept, so deadlines set by http.Server are kept,
+//connections with invalid header or without data in proxyHeaderTimeout are closed
+func NewProxyProtoListener(l net.Listener) net.Listener {
+	pl := &proxyProtoListener{Listener: l, conns: make(chan net.Conn), errs: make(chan error), done: make(chan struct{})}
+	go pl.serve()
+	return pl
+}
+
+type proxyProtoListener struct {
+	net.Listener
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+//serve accept connections and read their headers concurrently, a slow client does not block others
+func (l *proxyProtoListener) serve() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyProtoListener) handshake(conn net.Conn) {
+	c := &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn)}
+	//the connection is not handed to http.Server yet, no deadline of server is cleared
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	err := c.readHeader()
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *proxyProtoListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	reader *bufio.Reader
+	source net.Addr
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+//ProxyAddr source address declared by PROXY protocol header, nil if no header received
+func (c *proxyProtoConn) ProxyAddr() net.Addr {
+	return c.source
+}
+
+//readHeader read optional PROXY protocol header, error returned if header is invalid or no data is received
+func (c *proxyProtoConn) readHeader() error {
+	peek, err := c.reader.Peek(len(proxyV1Prefix))
+	if bytes.Equal(peek, proxyV1Prefix) {
+		c.source, err = readProxyV1(c.reader)
+		return err
+	}
+	//connection without header may send less bytes than the prefix
+	if len(peek) == 0 {
+		return err
+	}
+	if !bytes.HasPrefix(proxyV2Sig, peek) {
+		return nil
+	}
+	if peek, err = c.reader.Peek(len(proxyV2Sig)); err == nil && bytes.Equal(peek, proxyV2Sig) {
+		c.source, err = readProxyV2(c.reader)
+		return err
+	}
+	return nil
+}
+
+//readProxyV1 PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, errProxyHeader
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errProxyHeader
+	}
+	verCmd, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errProxyHeader
+	}
+	if verCmd>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	//LOCAL command, connection is from proxy itself
+	if verCmd&0x0f == 0 {
+		return nil, nil
+	}
+	switch family >> 4 {
+	case 1:
+		if length < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2:
+		if length < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	return nil, nil
+}
+
+type connContextKey struct{}
+
+//ConnContext store accepted connection into request context, set it as http.Server.ConnContext
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+//proxyProtoSource PROXY protocol source ip of request connection
+func proxyProtoSource(ctx context.Context) net.IP {
+	conn, ok := ctx.Value(connContextKey{}).(*proxyProtoConn)
+	if !ok {
+		return nil
+	}
+	if addr, ok := conn.ProxyAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
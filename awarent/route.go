@@ -0,0 +1,113 @@
+package awarent
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//RouteRule flow control rules for single route. Method and Path are matched against
+//http method and gin route pattern (c.FullPath()), such as GET /active/:cid.
+//FlowControlRules are resources(cid) limited under this route only.
+//MaxBodyBytes and AllowedMethods limit requests of the route, requests of wrong method reach the route only if Method is any.
+type RouteRule struct {
+	Method           string              `yaml:"method"`
+	Path             string              `yaml:"path"`
+	FlowControlRules []FlowControlOption `yaml:"flow-control-rules"`
+	MaxBodyBytes     int64               `yaml:"maxBodyBytes"`
+	AllowedMethods   []string            `yaml:"allowedMethods"`
+}
+
+const anyMethod = "*"
+
+//routeKey key of route, method is upper case, empty method means any method
+func routeKey(method, path string) string {
+	method = strings.ToUpper(method)
+	if method == "" {
+		method = anyMethod
+	}
+	return method + ":" + path
+}
+
+//resource sentinel resource name of cid under the route, such as GET:/q|bigdata
+func (r *RouteRule) resource(cid string) string {
+	return routeKey(r.Method, r.Path) + "|" + cid
+}
+
+//option find flow control option of cid under the route
+func (r *RouteRule) option(cid string) (FlowControlOption, bool) {
+	for _, opt := range r.FlowControlRules {
+		if opt.Resource == cid {
+			return opt, true
+		}
+	}
+	return FlowControlOption{}, false
+}
+
+//matchRoute find route rule of request. exact method is preferred to any method
+func (rule *Rule) matchRoute(c *gin.Context) (*RouteRule, bool) {
+	return rule.findRoute(c.Request.Method, c.FullPath())
+}
+
+//findRoute find route rule of method and gin route pattern
+func (rule *Rule) findRoute(method, fullPath string) (*RouteRule, bool) {
+	if len(rule.Routes) == 0 || fullPath == "" {
+		return nil, false
+	}
+	var matched *RouteRule
+	for i := range rule.Routes {
+		route := &rule.Routes[i]
+		if route.Path != fullPath {
+			continue
+		}
+		routeMethod := strings.ToUpper(route.Method)
+		if routeMethod == method {
+			return route, true
+		}
+		if (routeMethod == "" || routeMethod == anyMethod) && matched == nil {
+			matched = route
+		}
+	}
+	return matched, matched != nil
+}
+
+//option find flow control option of cid. route rules take precedence over global rules,
+//global rule of cid applies on routes without rule of the cid
+func (rule *Rule) option(route *RouteRule, cid string) (FlowControlOption, bool) {
+	if route = rule.scope(route, cid); route != nil {
+		return route.option(cid)
+	}
+	return rule.globalOption(cid)
+}
+
+//scope route of which flow control rule of cid applies, nil returned if global rule applies.
+//cid without any rule is nested under the matched route
+func (rule *Rule) scope(route *RouteRule, cid string) *RouteRule {
+	if route == nil {
+		return nil
+	}
+	if _, ok := route.option(cid); ok {
+		return route
+	}
+	if _, ok := rule.globalOption(cid); ok {
+		return nil
+	}
+	return route
+}
+
+func (rule *Rule) globalOption(cid string) (FlowControlOption, bool) {
+	for _, opt := range rule.FlowControlRules {
+		if opt.Resource == cid {
+			return opt, true
+		}
+	}
+	return FlowControlOption{}, false
+}
+
+//splitResource split sentinel resource name into route and cid. route is empty for global resource
+func splitResource(resource string) (route, cid string) {
+	if idx := strings.LastIndex(resource, "|"); idx >= 0 {
+		return resource[:idx], resource[idx+1:]
+	}
+	return "", resource
+}
@@ -0,0 +1,139 @@
+package awarent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alibaba/sentinel-golang/core/flow"
+	"github.com/gin-gonic/gin"
+)
+
+var routeRule = Rule{
+	FlowControlRules: []FlowControlOption{{Resource: "bigdata", Threshold: 100, QueryBlock: true}},
+	Routes: []RouteRule{
+		{Method: "*", Path: "/active/:cid", FlowControlRules: []FlowControlOption{{Resource: "bigdata", Threshold: 10}}},
+		{Method: "post", Path: "/active/:cid", FlowControlRules: []FlowControlOption{{Resource: "bigdata", Threshold: 20, QueryBlock: true}}},
+		{Path: "/q", FlowControlRules: []FlowControlOption{{Resource: "ads", Threshold: 30}}},
+	},
+}
+
+func TestFindRoute(t *testing.T) {
+	cases := []struct {
+		method, path string
+		want         string
+	}{
+		{"POST", "/active/:cid", "POST:/active/:cid"},
+		{"GET", "/active/:cid", "*:/active/:cid"},
+		{"DELETE", "/q", "*:/q"},
+		{"GET", "/active/x", ""},
+		{"GET", "", ""},
+	}
+	for _, c := range cases {
+		route, ok := routeRule.findRoute(c.method, c.path)
+		got := ""
+		if ok {
+			got = routeKey(route.Method, route.Path)
+		}
+		if got != c.want {
+			t.Errorf("findRoute(%s, %s) = %q, want %q", c.method, c.path, got, c.want)
+		}
+	}
+}
+
+func TestRouteResource(t *testing.T) {
+	cases := []struct {
+		route    RouteRule
+		cid      string
+		resource string
+	}{
+		{RouteRule{Method: "get", Path: "/q"}, "bigdata", "GET:/q|bigdata"},
+		{RouteRule{Path: "/active/:cid"}, "ads", "*:/active/:cid|ads"},
+	}
+	for _, c := range cases {
+		resource := c.route.resource(c.cid)
+		if resource != c.resource {
+			t.Errorf("resource = %q, want %q", resource, c.resource)
+		}
+		route, cid := splitResource(resource)
+		if route != routeKey(c.route.Method, c.route.Path) || cid != c.cid {
+			t.Errorf("splitResource(%q) = %q, %q", resource, route, cid)
+		}
+	}
+	if route, cid := splitResource("bigdata"); route != "" || cid != "bigdata" {
+		t.Errorf("splitResource of global resource = %q, %q", route, cid)
+	}
+}
+
+func TestLoadRouteFlowControlRules(t *testing.T) {
+	a := &Awarent{}
+	if _, err := a.loadFlowControlRules(routeRule, 2); err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	cases := map[string]float64{
+		"bigdata":                   50,
+		"*:/active/:cid|bigdata":    5,
+		"POST:/active/:cid|bigdata": 10,
+		"*:/q|ads":                  15,
+	}
+	for resource, want := range cases {
+		rules := flow.GetRulesOfResource(resource)
+		if len(rules) != 1 || rules[0].Threshold != want {
+			t.Errorf("rules of %s = %v, want threshold %v", resource, rules, want)
+		}
+	}
+}
+
+func TestRouteQueryBlock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, err := newRuleSnapshot(routeRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocked bool
+	e := gin.New()
+	handler := func(c *gin.Context) { blocked = s.queryBlock(c, c.Query("cid")) }
+	e.Any("/active/:cid", handler)
+	e.GET("/other", handler)
+	e.GET("/q", handler)
+	cases := []struct {
+		method, url string
+		want        bool
+	}{
+		{http.MethodPost, "/active/x?cid=bigdata", true},
+		{http.MethodGet, "/active/x?cid=bigdata", false},
+		{http.MethodGet, "/other?cid=bigdata", true},
+		{http.MethodGet, "/other?cid=ads", false},
+		{http.MethodGet, "/q?cid=bigdata", true},
+		{http.MethodGet, "/q?cid=ads", false},
+	}
+	for _, c := range cases {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(c.method, c.url, nil))
+		if blocked != c.want {
+			t.Errorf("%s %s queryBlock = %v, want %v", c.method, c.url, blocked, c.want)
+		}
+	}
+}
+
+func TestRouteScope(t *testing.T) {
+	q, _ := routeRule.findRoute(http.MethodGet, "/q")
+	cases := []struct {
+		cid, resource string
+	}{
+		{"ads", "*:/q|ads"},
+		{"bigdata", "bigdata"},
+		{"other", "*:/q|other"},
+	}
+	for _, c := range cases {
+		resource := c.cid
+		if route := routeRule.scope(q, c.cid); route != nil {
+			resource = route.resource(c.cid)
+		}
+		if resource != c.resource {
+			t.Errorf("resource of %s on /q = %q, want %q", c.cid, resource, c.resource)
+		}
+	}
+	if opt, ok := routeRule.option(q, "bigdata"); !ok || !opt.QueryBlock {
+		t.Errorf("option of bigdata on /q = %+v, %v, want global option", opt, ok)
+	}
+}
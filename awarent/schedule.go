@@ -0,0 +1,140 @@
+package awarent
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Schedule time limit of access entry. entry is active from NotBefore until Expires, and only within Windows if configured.
+//zero NotBefore or Expires means no limit
+type Schedule struct {
+	NotBefore time.Time    `yaml:"notBefore"`
+	Expires   time.Time    `yaml:"expires"`
+	Windows   []TimeWindow `yaml:"windows"`
+}
+
+//TimeWindow weekly time of day window. Days are mon to sun(every day if empty), Start and End are HH:MM,
+//End before Start means the window ends next day. Timezone is IANA name such as Asia/Shanghai, local timezone by default
+type TimeWindow struct {
+	Days     []string `yaml:"days"`
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+	Timezone string   `yaml:"timezone"`
+}
+
+//TimedEntries ip, cidr or hostname entries with schedule
+type TimedEntries struct {
+	IPS      []string `yaml:"ips"`
+	Schedule `yaml:",inline"`
+}
+
+type schedule struct {
+	notBefore time.Time
+	expires   time.Time
+	windows   []timeWindow
+}
+
+type timeWindow struct {
+	days       [7]bool
+	start, end int
+	loc        *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+//compile nil returned when schedule has no limit
+func (s *Schedule) compile() (*schedule, error) {
+	if s.NotBefore.IsZero() && s.Expires.IsZero() && len(s.Windows) == 0 {
+		return nil, nil
+	}
+	compiled := &schedule{notBefore: s.NotBefore, expires: s.Expires}
+	for _, w := range s.Windows {
+		window, err := w.compile()
+		if err != nil {
+			return nil, err
+		}
+		compiled.windows = append(compiled.windows, window)
+	}
+	return compiled, nil
+}
+
+func (w *TimeWindow) compile() (timeWindow, error) {
+	window := timeWindow{loc: time.Local}
+	if len(w.Timezone) > 0 {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return window, fmt.Errorf("invalid timezone %q", w.Timezone)
+		}
+		window.loc = loc
+	}
+	for _, day := range w.Days {
+		name := strings.ToLower(strings.TrimSpace(day))
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		weekday, ok := weekdays[name]
+		if !ok {
+			return window, fmt.Errorf("invalid day %q", day)
+		}
+		window.days[weekday] = true
+	}
+	if len(w.Days) == 0 {
+		window.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	var err error
+	if window.start, err = parseClock(w.Start); err != nil {
+		return window, err
+	}
+	if window.end, err = parseClock(w.End); err != nil {
+		return window, err
+	}
+	return window, nil
+}
+
+//parseClock minutes of day of HH:MM
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+//active whether schedule is active at now, nil schedule is always active
+func (s *schedule) active(now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	if !s.notBefore.IsZero() && now.Before(s.notBefore) {
+		return false
+	}
+	if !s.expires.IsZero() && !now.Before(s.expires) {
+		return false
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+	for i := range s.windows {
+		if s.windows[i].contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *timeWindow) contains(now time.Time) bool {
+	local := now.In(w.loc)
+	minute := local.Hour()*60 + local.Minute()
+	if w.start <= w.end {
+		return w.days[local.Weekday()] && minute >= w.start && minute < w.end
+	}
+	//overnight window belongs to the day it starts
+	if minute >= w.start {
+		return w.days[local.Weekday()]
+	}
+	return minute < w.end && w.days[(local.Weekday()+6)%7]
+}
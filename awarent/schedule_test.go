@@ -0,0 +1,97 @@
+package awarent
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	s, err := (&Schedule{
+		NotBefore: time.Date(2026, 1, 1, 0, 0, 0, 0, loc),
+		Expires:   time.Date(2026, 2, 1, 0, 0, 0, 0, loc),
+		Windows: []TimeWindow{
+			{Days: []string{"Mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "18:00", Timezone: "Asia/Shanghai"},
+			{Days: []string{"sat"}, Start: "22:00", End: "02:00", Timezone: "Asia/Shanghai"},
+		},
+	}).compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[time.Time]bool{
+		//thursday
+		time.Date(2026, 1, 8, 10, 0, 0, 0, loc): true,
+		time.Date(2026, 1, 8, 18, 0, 0, 0, loc): false,
+		time.Date(2026, 1, 8, 2, 0, 0, 0, loc):  false,
+		//saturday night and early sunday
+		time.Date(2026, 1, 10, 23, 0, 0, 0, loc): true,
+		time.Date(2026, 1, 11, 1, 0, 0, 0, loc):  true,
+		time.Date(2026, 1, 11, 23, 0, 0, 0, loc): false,
+		//before notBefore and after expires
+		time.Date(2025, 12, 31, 10, 0, 0, 0, loc): false,
+		time.Date(2026, 2, 2, 10, 0, 0, 0, loc):   false,
+	}
+	for now, want := range cases {
+		if got := s.active(now); got != want {
+			t.Errorf("%s got %v, want %v", now, got, want)
+		}
+	}
+	if _, err := (&Schedule{Windows: []TimeWindow{{Days: []string{"someday"}, Start: "09:00", End: "18:00"}}}).compile(); err == nil {
+		t.Errorf("invalid day should fail")
+	}
+}
+
+func TestTimedEntries(t *testing.T) {
+	now := time.Now()
+	f := New(FilterOptions{
+		BlockByDefault: true,
+		AllowedIPs:     []string{"10.0.0.0/8"},
+		TimedAllowed:   []TimedEntries{{IPS: []string{"172.16.0.1"}, Schedule: Schedule{Expires: now.Add(time.Hour)}}},
+		TimedBlocked:   []TimedEntries{{IPS: []string{"10.1.1.1"}, Schedule: Schedule{NotBefore: now.Add(time.Hour)}}},
+		AuthorizedIPs: []Authorized{
+			{Resource: "trial", IPS: []string{"10.0.0.0/8"}, Schedule: Schedule{Expires: now.Add(time.Hour)}},
+			{Resource: "trial", IPS: []string{"10.2.0.0/16"}},
+		},
+	})
+	later := now.Add(2 * time.Hour)
+	cases := []struct {
+		ip, param string
+		at        time.Time
+		want      bool
+	}{
+		{"172.16.0.1", "", now, true},
+		{"172.16.0.1", "", later, false},
+		{"10.1.1.1", "", now, true},
+		{"10.1.1.1", "", later, false},
+		{"10.3.0.1", "trial", now, true},
+		{"10.3.0.1", "trial", later, false},
+		{"10.2.0.1", "trial", later, true},
+	}
+	for _, c := range cases {
+		var got bool
+		if len(c.param) == 0 {
+			got = f.access(c.ip, c.at).Allowed
+		} else {
+			got = f.decide(nil, &filterRequest{ip: c.ip, cid: c.param, now: c.at}, "").Allowed
+		}
+		if got != c.want {
+			t.Errorf("ip %s param %q at %s got %v, want %v", c.ip, c.param, c.at, got, c.want)
+		}
+	}
+}
+
+func TestInvalidSchedule(t *testing.T) {
+	invalid := Schedule{Windows: []TimeWindow{{Start: "09:00", End: "18:00", Timezone: "Asia/Shangai"}}}
+	cases := map[string]FilterOptions{
+		"timedBlocked": {TimedBlocked: []TimedEntries{{IPS: []string{"192.0.2.1"}, Schedule: invalid}}},
+		"authorized":   {AuthorizedIPs: []Authorized{{Resource: "trial", IPS: []string{"192.0.2.1"}, Schedule: invalid}}},
+	}
+	for name, opts := range cases {
+		f := New(opts)
+		if err := f.Err(); err == nil || !strings.Contains(err.Error(), "Asia/Shangai") {
+			t.Errorf("%s got error %v, want invalid timezone", name, err)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,314 @@
+package awarent
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+	"gopkg.in/yaml.v2"
+)
+
+//headers of signed request
+const (
+	HeaderSignature = "X-Signature"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+
+	defaultSigningWindow  = 5 * time.Minute
+	defaultSigningMaxBody = 10 << 20
+	maxNonceLength        = 128
+)
+
+//SigningOptions HMAC request signing of Resources, checked by SignatureAuth middleware.
+//secrets are yaml map of resource to secrets in nacos dataId SecretsID, several secrets are allowed for rotation.
+//timestamp must be within Window(5m by default) of server time and nonce can not be reused within the window.
+//body of signed request is limited to maxBodyBytes of the resource, or MaxBodyBytes(10MB by default) if not limited
+type SigningOptions struct {
+	SecretsID    string        `yaml:"secretsId"`
+	Resources    []string      `yaml:"resources"`
+	Window       time.Duration `yaml:"window"`
+	MaxBodyBytes int64         `yaml:"maxBodyBytes"`
+}
+
+func (opts *SigningOptions) window() time.Duration {
+	if opts.Window > 0 {
+		return opts.Window
+	}
+	return defaultSigningWindow
+}
+
+func (opts *SigningOptions) maxBody() int64 {
+	if opts.MaxBodyBytes > 0 {
+		return opts.MaxBodyBytes
+	}
+	return defaultSigningMaxBody
+}
+
+func (opts *SigningOptions) signed(resource string) bool {
+	for _, r := range opts.Resources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	errSignatureMissing = errors.New("missing signature")
+	errSignatureInvalid = errors.New("invalid signature")
+	errTimestamp        = errors.New("timestamp out of window")
+	errNonce            = errors.New("invalid nonce")
+	errNonceReplayed    = errors.New("nonce replayed")
+	errNoSecret         = errors.New("no secret of resource")
+	errBodyTooLarge     = errors.New("request body too large")
+)
+
+//signingState secrets loaded from nacos and nonces seen, state is kept across rule reloads
+type signingState struct {
+	secrets  atomic.Value
+	mu       sync.Mutex
+	dataID   string
+	nonces   map[string]time.Time
+	swept    time.Time
+	watching map[string]bool
+}
+
+//setSecrets parse yaml map of resource to secrets, current secrets are kept on error
+func (st *signingState) setSecrets(content string) error {
+	var raw map[string][]string
+	if err := yaml.Unmarshal([]byte(content), &raw); err != nil {
+		return err
+	}
+	secrets := make(map[string][][]byte, len(raw))
+	for resource, values := range raw {
+		for _, value := range values {
+			if len(value) > 0 {
+				secrets[resource] = append(secrets[resource], []byte(value))
+			}
+		}
+	}
+	st.secrets.Store(secrets)
+	return nil
+}
+
+func (st *signingState) secretsOf(resource string) [][]byte {
+	secrets, _ := st.secrets.Load().(map[string][][]byte)
+	return secrets[resource]
+}
+
+//useNonce record nonce of resource, false returned if nonce is seen within window
+func (st *signingState) useNonce(resource, nonce string, now time.Time, window time.Duration) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.nonces == nil {
+		st.nonces = map[string]time.Time{}
+	}
+	//timestamps are accepted within window in both directions, nonces are kept for twice the window
+	if now.Sub(st.swept) >= window {
+		st.swept = now
+		for key, expires := range st.nonces {
+			if !now.Before(expires) {
+				delete(st.nonces, key)
+			}
+		}
+	}
+	key := resource + "|" + nonce
+	if expires, ok := st.nonces[key]; ok && now.Before(expires) {
+		return false
+	}
+	st.nonces[key] = now.Add(2 * window)
+	return true
+}
+
+//watchSecrets load secrets from nacos dataId and listen on its change, secrets of other dataId are ignored once dataId changed.
+//secrets are loaded again whenever dataId changes, the listener of a dataId is registered only once.
+//secrets are cleared when dataId is removed, content of secrets is never logged
+func (a *Awarent) watchSecrets(dataID string) {
+	st := &a.signing
+	st.mu.Lock()
+	changed := st.dataID != dataID
+	st.dataID = dataID
+	if len(dataID) == 0 && changed {
+		st.secrets.Store(map[string][][]byte{})
+	}
+	if len(dataID) == 0 || a.configClient == nil || !changed {
+		st.mu.Unlock()
+		return
+	}
+	if st.watching == nil {
+		st.watching = map[string]bool{}
+	}
+	listening := st.watching[dataID]
+	st.watching[dataID] = true
+	st.mu.Unlock()
+	load := func(content string) {
+		st.mu.Lock()
+		current := st.dataID == dataID
+		st.mu.Unlock()
+		if !current {
+			return
+		}
+		if err := st.setSecrets(content); err != nil {
+			log.Printf("decode signing secrets %s error:%v, keep current secrets\n", dataID, err)
+		}
+	}
+	content, err := a.GetConfig(dataID)
+	if err != nil {
+		log.Printf("get signing secrets %s error:%v\n", dataID, err)
+	} else {
+		load(content)
+	}
+	if listening {
+		return
+	}
+	//watchSecrets may run in config change callback of rule, listen asynchronously.
+	//ConfigOnChange prints changed content, listen directly to keep secrets out of stdout
+	go func() {
+		err := a.configClient.ListenConfig(vo.ConfigParam{
+			Group:    a.group,
+			DataId:   dataID,
+			OnChange: func(namespace, group, dataId, data string) { load(data) },
+		})
+		if err != nil {
+			log.Printf("listen signing secrets %s error:%v\n", dataID, err)
+		}
+	}()
+}
+
+//SignatureAuth HMAC-SHA256 signature verification of resources configured in signing options, other requests pass through.
+//signature is hex HMAC of METHOD\nPATH\nSORTED_QUERY\nBODY_SHA256_HEX\nTIMESTAMP\nNONCE with secret of resource,
+//timestamp is unix seconds. invalid or replayed request is rejected with 401 and observed for automatic bans
+func (a *Awarent) SignatureAuth() gin.HandlerFunc {
+	return a.withBans(func(c *gin.Context) {
+		s := a.requestSnapshot(c)
+		opts := &s.rule.IPFilterRules.Signing
+		if len(opts.Resources) == 0 {
+			c.Next()
+			return
+		}
+		_, param, ok := s.ipTarget(c)
+		if !ok || !opts.signed(param) {
+			c.Next()
+			return
+		}
+		now := time.Now()
+		maxBody, _ := s.requestLimits(c, param)
+		if maxBody <= 0 {
+			maxBody = opts.maxBody()
+		}
+		if err := a.verifySignature(c, opts, param, now, maxBody); err != nil {
+			s.audit.record(newAuditEvent(c, now, a.clientIP(c), param, Decision{Reason: ReasonSignature, Rule: err.Error()}))
+			status := http.StatusUnauthorized
+			if err == errBodyTooLarge {
+				status = http.StatusRequestEntityTooLarge
+			}
+			c.AbortWithStatus(status)
+			return
+		}
+		c.Next()
+	})
+}
+
+func (a *Awarent) verifySignature(c *gin.Context, opts *SigningOptions, resource string, now time.Time, maxBody int64) error {
+	signature, err := hex.DecodeString(c.GetHeader(HeaderSignature))
+	if err != nil || len(signature) == 0 {
+		return errSignatureMissing
+	}
+	timestamp := c.GetHeader(HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errTimestamp
+	}
+	window := opts.window()
+	if skew := now.Sub(time.Unix(unix, 0)); skew > window || skew < -window {
+		return errTimestamp
+	}
+	nonce := c.GetHeader(HeaderNonce)
+	if len(nonce) == 0 || len(nonce) > maxNonceLength {
+		return errNonce
+	}
+	secrets := a.signing.secretsOf(resource)
+	if len(secrets) == 0 {
+		return errNoSecret
+	}
+	payload, err := signingPayload(c.Request, timestamp, nonce, maxBody)
+	if err != nil {
+		return err
+	}
+	verified := false
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			verified = true
+		}
+	}
+	if !verified {
+		return errSignatureInvalid
+	}
+	if !a.signing.useNonce(resource, nonce, now, window) {
+		return errNonceReplayed
+	}
+	return nil
+}
+
+//signingPayload canonical request to sign, request body is restored for handlers.
+//errBodyTooLarge returned if body is larger than maxBody, zero maxBody means no limit
+func signingPayload(req *http.Request, timestamp, nonce string, maxBody int64) ([]byte, error) {
+	var body []byte
+	if req.Body != nil {
+		if maxBody > 0 && req.ContentLength > maxBody {
+			return nil, errBodyTooLarge
+		}
+		var reader io.Reader = req.Body
+		if maxBody > 0 {
+			reader = io.LimitReader(req.Body, maxBody+1)
+		}
+		var err error
+		if body, err = ioutil.ReadAll(reader); err != nil {
+			return nil, err
+		}
+		if maxBody > 0 && int64(len(body)) > maxBody {
+			return nil, errBodyTooLarge
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	bodyHash := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		req.Method,
+		req.URL.Path,
+		req.URL.Query().Encode(),
+		hex.EncodeToString(bodyHash[:]),
+		timestamp,
+		nonce,
+	}, "\n")), nil
+}
+
+//SignRequest sign request with secret for client side, timestamp and nonce headers are set
+func SignRequest(req *http.Request, secret, nonce string, now time.Time) error {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	payload, err := signingPayload(req, timestamp, nonce, 0)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
@@ -0,0 +1,118 @@
+package awarent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignatureAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		IPFilterRules: FilterOptions{
+			URLPath:  "/q",
+			URLParam: "cid",
+			Signing:  SigningOptions{Resources: []string{"vip"}, Window: time.Minute},
+		},
+	})
+	if err := a.signing.setSecrets("vip: [old, secret]\n"); err != nil {
+		t.Fatal(err)
+	}
+	e := gin.New()
+	e.Use(a.SignatureAuth())
+	e.POST("/q", func(c *gin.Context) {
+		body, _ := c.GetRawData()
+		c.String(http.StatusOK, string(body))
+	})
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		return w
+	}
+	newRequest := func(query string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/q?"+query, strings.NewReader(`{"id":1}`))
+	}
+	now := time.Now()
+
+	req := newRequest("cid=vip&b=2&a=1")
+	SignRequest(req, "secret", "n1", now)
+	if w := serve(req); w.Code != http.StatusOK || w.Body.String() != `{"id":1}` {
+		t.Errorf("signed request got %d %q, want 200 with body", w.Code, w.Body.String())
+	}
+	replay := newRequest("cid=vip&b=2&a=1")
+	SignRequest(replay, "secret", "n1", now)
+	if w := serve(replay); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed nonce got %d, want 401", w.Code)
+	}
+	stale := newRequest("cid=vip")
+	SignRequest(stale, "secret", "n2", now.Add(-2*time.Minute))
+	if w := serve(stale); w.Code != http.StatusUnauthorized {
+		t.Errorf("stale timestamp got %d, want 401", w.Code)
+	}
+	tampered := newRequest("cid=vip")
+	SignRequest(tampered, "secret", "n3", now)
+	tampered.URL.RawQuery += "&extra=1"
+	if w := serve(tampered); w.Code != http.StatusUnauthorized {
+		t.Errorf("tampered query got %d, want 401", w.Code)
+	}
+	rotated := newRequest("cid=vip")
+	SignRequest(rotated, "old", "n4", now)
+	if w := serve(rotated); w.Code != http.StatusOK {
+		t.Errorf("request signed with previous secret got %d, want 200", w.Code)
+	}
+	if w := serve(newRequest("cid=vip")); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request got %d, want 401", w.Code)
+	}
+	if w := serve(newRequest("cid=other")); w.Code != http.StatusOK {
+		t.Errorf("resource without signing got %d, want 200", w.Code)
+	}
+}
+
+func TestSignatureBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{
+		ResourceParam: "cid",
+		IPFilterRules: FilterOptions{
+			URLPath:  "/q",
+			URLParam: "cid",
+			Signing:  SigningOptions{Resources: []string{"vip"}, MaxBodyBytes: 8},
+		},
+	})
+	if err := a.signing.setSecrets("vip: [secret]\n"); err != nil {
+		t.Fatal(err)
+	}
+	e := gin.New()
+	e.Use(a.SignatureAuth())
+	e.POST("/q", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for _, length := range []int64{9, -1} {
+		req := httptest.NewRequest(http.MethodPost, "/q?cid=vip", strings.NewReader(`{"id":123}`))
+		SignRequest(req, "secret", "n", time.Now())
+		req.ContentLength = length
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("body over limit with length %d got %d, want 413", length, w.Code)
+		}
+	}
+}
+
+func TestWatchSecretsCleared(t *testing.T) {
+	a := &Awarent{}
+	a.watchSecrets("awarent-signing-secrets")
+	if err := a.signing.setSecrets("vip: [secret]\n"); err != nil {
+		t.Fatal(err)
+	}
+	a.watchSecrets("")
+	if secrets := a.signing.secretsOf("vip"); len(secrets) != 0 {
+		t.Errorf("secrets %q kept after secretsId removed", secrets)
+	}
+}
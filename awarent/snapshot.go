@@ -0,0 +1,225 @@
+package awarent
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//ruleSnapshot immutable rule compiled with resource extractor and ip filter. snapshot is swapped atomically
+//as a whole when rule changed, so a request see the same rule in ip filter and flow control
+type ruleSnapshot struct {
+	rule        Rule
+	extract     ResourceExtractor
+	clientIP    *clientIPResolver
+	filter      *Filter
+	banIgnore   *ipTrie
+	audit       *auditLog
+	bypass      *bypassFilter
+	maintenance *maintenance
+	faults      []FaultRule
+}
+
+const (
+	snapshotKey = "awarent.snapshot"
+	resourceKey = "awarent.resource"
+)
+
+//newRuleSnapshot compile rule, error returned if rule can not be applied safely
+func newRuleSnapshot(rule Rule) (*ruleSnapshot, error) {
+	extract, err := NewResourceExtractor(rule.ResourceExtractor)
+	if err != nil {
+		return nil, fmt.Errorf("compile resource extractor: %v", err)
+	}
+	faults, err := newFaults(rule.FaultInjection)
+	if err != nil {
+		return nil, err
+	}
+	filter := New(rule.IPFilterRules)
+	if err := filter.Err(); err != nil {
+		filter.Close()
+		return nil, err
+	}
+	return &ruleSnapshot{
+		rule:        rule,
+		extract:     extract,
+		clientIP:    newClientIPResolver(rule.IPFilterRules),
+		filter:      filter,
+		banIgnore:   newBanIgnore(rule.IPFilterRules.Bans),
+		audit:       newAuditLog(rule.IPFilterRules.Audit),
+		bypass:      newBypassFilter(rule.Bypass),
+		maintenance: newMaintenance(rule.Maintenance),
+		faults:      faults,
+	}, nil
+}
+
+var emptySnapshot, _ = newRuleSnapshot(Rule{})
+
+//setRule compile rule and swap current rule snapshot, current snapshot is kept if rule can not be compiled
+func (a *Awarent) setRule(rule Rule) error {
+	s, err := newRuleSnapshot(rule)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.swap(s)
+	a.mu.Unlock()
+	a.watchSecrets(rule.IPFilterRules.Signing.SecretsID)
+	if len(s.faults) > 0 && productionGroup(a.group) && !rule.FaultInjection.AllowProduction {
+		log.Printf("fault injection ignored in production group %s, set allowProduction to enable\n", a.group)
+	}
+	if s.filter.hostnames {
+		a.resolveOnce.Do(func() {
+			go a.refreshHostnames()
+		})
+	}
+	return nil
+}
+
+//swap replace current rule snapshot with s under mu, shared resources of the replaced snapshot are released
+func (a *Awarent) swap(s *ruleSnapshot) {
+	old, _ := a.current.Load().(*ruleSnapshot)
+	a.current.Store(s)
+	if old != nil {
+		old.filter.Close()
+		old.audit.close()
+	}
+}
+
+//snapshot current rule snapshot
+func (a *Awarent) snapshot() *ruleSnapshot {
+	if s, ok := a.current.Load().(*ruleSnapshot); ok {
+		return s
+	}
+	return emptySnapshot
+}
+
+//refreshHostnames periodically rebuild current snapshot so that hostname entries of ip filter are re-resolved
+func (a *Awarent) refreshHostnames() {
+	for {
+		time.Sleep(a.snapshot().filter.resolveInterval())
+		a.mu.Lock()
+		if s := a.snapshot(); s.filter.hostnames {
+			if refreshed, err := newRuleSnapshot(s.rule); err == nil {
+				a.swap(refreshed)
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+//requestSnapshot rule snapshot bound to request, the first middleware binds current snapshot
+func (a *Awarent) requestSnapshot(c *gin.Context) *ruleSnapshot {
+	if val, ok := c.Get(snapshotKey); ok {
+		if s, ok := val.(*ruleSnapshot); ok {
+			return s
+		}
+	}
+	s := a.snapshot()
+	c.Set(snapshotKey, s)
+	return s
+}
+
+//ipProtected whether path is protected by ip filter urlPath. query param mode protects urlPath only,
+//empty urlPath protects nothing once protected paths configured
+func (s *ruleSnapshot) ipProtected(path string) bool {
+	opts := &s.rule.IPFilterRules
+	if len(opts.URLPath) == 0 && len(opts.Paths) > 0 {
+		return false
+	}
+	if s.extract == nil && len(opts.URLParam) > 0 {
+		return path == opts.URLPath
+	}
+	return protectedPath(opts.URLPath, path)
+}
+
+//ipTarget protected path and resource of request for ip filter, false returned if path is not protected
+func (s *ruleSnapshot) ipTarget(c *gin.Context) (*pathFilter, string, bool) {
+	p, ok := s.filter.matchPath(c.Request.URL.Path)
+	if ok {
+		return p, p.resource(c, s.ipResource), true
+	}
+	if s.ipProtected(c.Request.URL.Path) {
+		return nil, s.ipResource(c), true
+	}
+	return nil, "", false
+}
+
+//ipResource resource of request for ip filter authorization
+func (s *ruleSnapshot) ipResource(c *gin.Context) string {
+	opts := &s.rule.IPFilterRules
+	switch {
+	case s.extract != nil:
+		return s.extractResource(c)
+	case len(opts.URLParam) > 0:
+		return c.Query(opts.URLParam)
+	}
+	return pathResource(c, opts.URLPathParam)
+}
+
+//flowProtected whether request is under flow control, matched route rules are always protected
+func (s *ruleSnapshot) flowProtected(c *gin.Context) bool {
+	if _, ok := s.rule.matchRoute(c); ok {
+		return true
+	}
+	return s.flowPathProtected(c.Request.URL.Path)
+}
+
+//flowPathProtected whether path is under flow control by urlPath of rule
+func (s *ruleSnapshot) flowPathProtected(path string) bool {
+	if s.extract == nil && len(s.rule.ResourceParam) > 0 {
+		return path == s.rule.IPFilterRules.URLPath
+	}
+	return protectedPath(s.rule.IPFilterRules.URLPath, path)
+}
+
+//flowResource resource(cid) of request for flow control
+func (s *ruleSnapshot) flowResource(c *gin.Context) string {
+	switch {
+	case s.extract != nil:
+		return s.extractResource(c)
+	case len(s.rule.ResourceParam) > 0:
+		return c.Query(s.rule.ResourceParam)
+	}
+	return pathResource(c, s.rule.pathParam())
+}
+
+//extractResource resource extracted by rule resource extractor. extraction such as jwt verification runs once per request,
+//the result is shared by middlewares
+func (s *ruleSnapshot) extractResource(c *gin.Context) string {
+	if resource, ok := c.Get(resourceKey); ok {
+		return resource.(string)
+	}
+	resource := s.extract(c)
+	c.Set(resourceKey, resource)
+	return resource
+}
+
+//resourceName sentinel resource name of cid, cid is nested under route if request matched a route rule
+//and global rule of cid does not apply
+func (s *ruleSnapshot) resourceName(c *gin.Context, cid string) string {
+	route, _ := s.rule.matchRoute(c)
+	if route = s.rule.scope(route, cid); route != nil {
+		return route.resource(cid)
+	}
+	return cid
+}
+
+//queryBlock whether cid is blocked by flow control option of the matched route or global rules
+func (s *ruleSnapshot) queryBlock(c *gin.Context, cid string) bool {
+	route, _ := s.rule.matchRoute(c)
+	if opt, ok := s.rule.option(route, cid); ok {
+		return opt.QueryBlock
+	}
+	return false
+}
+
+//pathParam gin route param name of resource in path mode, fallback to ip filter urlPathParam
+func (rule *Rule) pathParam() string {
+	if len(rule.ResourcePathParam) > 0 {
+		return rule.ResourcePathParam
+	}
+	return rule.IPFilterRules.URLPathParam
+}
@@ -0,0 +1,114 @@
+package awarent
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+//TestConcurrentReload run with go test -race, requests are served while rules are reloaded
+func TestConcurrentReload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rules := []Rule{
+		{
+			ResourceParam: "cid",
+			IPFilterRules: FilterOptions{
+				URLPath:       "/q",
+				URLParam:      "cid",
+				AllowedIPs:    []string{"192.0.2.0/24"},
+				AuthorizedIPs: []Authorized{{Resource: "bigdata", IPS: []string{"192.0.2.1"}}},
+			},
+		},
+		{
+			ResourceExtractor: ExtractorOptions{Sources: []string{"param:cid"}},
+			IPFilterRules: FilterOptions{
+				Paths: []PathOptions{{Pattern: "/active/**"}},
+				AuthorizedIPs: []Authorized{
+					{Resource: "bigdata", IPS: []string{"192.0.2.0/24"}},
+				},
+				BlockByDefault: true,
+			},
+		},
+	}
+	a := &Awarent{}
+	a.setRule(rules[0])
+	e := gin.New()
+	e.Use(a.IPFilter(), a.Sentinel())
+	e.GET("/q", func(c *gin.Context) { c.Status(http.StatusOK) })
+	e.GET("/active/:cid", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				target := "/q?cid=bigdata"
+				if j%2 == 0 {
+					target = "/active/bigdata"
+				}
+				req := httptest.NewRequest(http.MethodGet, target, nil)
+				req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i+1)
+				w := httptest.NewRecorder()
+				e.ServeHTTP(w, req)
+				if w.Code != http.StatusOK && w.Code != http.StatusForbidden && w.Code != http.StatusTooManyRequests {
+					t.Errorf("unexpected status %d", w.Code)
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 200; i++ {
+		a.setRule(rules[i%2])
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestRuleWithInvalidExtractorKeepsSnapshot(t *testing.T) {
+	a := &Awarent{}
+	valid := Rule{ResourceExtractor: ExtractorOptions{Sources: []string{"header:X-Client-Id"}}}
+	if err := a.setRule(valid); err != nil {
+		t.Fatal(err)
+	}
+	current := a.snapshot()
+	invalid := Rule{ResourceExtractor: ExtractorOptions{
+		Sources: []string{"jwt:sub"},
+		JWT:     JWTOptions{Algorithm: "RS256", KeyFile: "/nonexistent/key.pem"},
+	}}
+	if err := a.setRule(invalid); err == nil {
+		t.Errorf("rule with invalid extractor applied")
+	}
+	if a.snapshot() != current {
+		t.Errorf("current snapshot replaced by invalid rule")
+	}
+}
+
+func TestResourceExtractedOncePerRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Awarent{}
+	a.setRule(Rule{ResourceExtractor: ExtractorOptions{Sources: []string{"header:X-Client-Id"}}})
+	var first, second string
+	e := gin.New()
+	e.GET("/q", func(c *gin.Context) {
+		s := a.requestSnapshot(c)
+		first = s.flowResource(c)
+		c.Request.Header.Set("X-Client-Id", "changed")
+		second = s.ipResource(c)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/q", nil)
+	req.Header.Set("X-Client-Id", "bigdata")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	if first != "bigdata" || second != "bigdata" {
+		t.Errorf("resources %q and %q, want bigdata extracted once", first, second)
+	}
+}
@@ -25,6 +25,7 @@ func init() {
 
 type req struct {
 	RuleId  string `json:"rule_id"`
+	Route   string `json:"route,omitempty"`
 	Cid     string `json:"cid"`
 	Queries int64  `json:"queries"`
 }
@@ -55,8 +56,9 @@ func (s summaryMap) add(ruleId, cid string, queries interface{}) {
 	}
 }
 
-func (s summaryMap) send(ruleId, cid string, queries int64) {
-	reqBody, err := json.Marshal(req{RuleId: ruleId, Cid: cid, Queries: queries})
+func (s summaryMap) send(ruleId, resource string, queries int64) {
+	route, cid := splitResource(resource)
+	reqBody, err := json.Marshal(req{RuleId: ruleId, Route: route, Cid: cid, Queries: queries})
 	if err != nil {
 		return
 	}
@@ -0,0 +1,150 @@
+package awarent
+
+import (
+	"net"
+	"strings"
+)
+
+//ipTrie binary prefix trie of ip networks, lookup returns value of the most specific network containing ip.
+//ipv4 and ipv6 networks have separate roots, so that ipv6 network such as ::/0 never contains ipv4 address.
+//ipv4-mapped ipv6 address is treated as ipv4
+type ipTrie struct {
+	v4   *trieNode
+	v6   *trieNode
+	size int
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	value    interface{}
+	set      bool
+}
+
+func newIPTrie() *ipTrie {
+	return &ipTrie{v4: &trieNode{}, v6: &trieNode{}}
+}
+
+//parseNetwork parse ip or cidr entry, single ip is treated as /32 or /128 network.
+//ipv4 network is returned as 4 bytes ip, ipv4-mapped ipv6 network is converted to ipv4 network.
+//ipv6 network containing ipv4-mapped addresses such as ::/0 is ipv6 only
+func parseNetwork(entry string) (net.IP, int, bool) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, 0, false
+		}
+		ones, bits := ipNet.Mask.Size()
+		if v4 := ipNet.IP.To4(); v4 != nil && (bits == 32 || ones >= 96) {
+			return v4, ones - (bits - 32), true
+		}
+		return ipNet.IP.To16(), ones, true
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, 0, false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4, 32, true
+	}
+	return ip, 128, true
+}
+
+//root root node of ip family and ip of the family, nil returned if ip is invalid
+func (t *ipTrie) root(ip net.IP) (*trieNode, net.IP) {
+	if v4 := ip.To4(); v4 != nil {
+		return t.v4, v4
+	}
+	if v6 := ip.To16(); v6 != nil {
+		return t.v6, v6
+	}
+	return nil, nil
+}
+
+//insert set value of network. existing value of the same network is kept unless overwrite
+func (t *ipTrie) insert(ip net.IP, prefixLen int, value interface{}, overwrite bool) {
+	node, ip := t.root(ip)
+	for i := 0; i < prefixLen; i++ {
+		bit := ipBit(ip, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	if node.set && !overwrite {
+		return
+	}
+	if !node.set {
+		t.size++
+	}
+	node.value = value
+	node.set = true
+}
+
+//insertEntry parse and insert ip or cidr entry, false returned if entry is invalid
+func (t *ipTrie) insertEntry(entry string, value interface{}, overwrite bool) bool {
+	ip, prefixLen, ok := parseNetwork(entry)
+	if !ok {
+		return false
+	}
+	t.insert(ip, prefixLen, value, overwrite)
+	return true
+}
+
+//update set value of network to result of fn, old is nil if network not set.
+//false returned if entry is invalid
+func (t *ipTrie) updateEntry(entry string, fn func(old interface{}) interface{}) bool {
+	ip, prefixLen, ok := parseNetwork(entry)
+	if !ok {
+		return false
+	}
+	node, ip := t.root(ip)
+	for i := 0; i < prefixLen; i++ {
+		bit := ipBit(ip, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	if !node.set {
+		t.size++
+	}
+	node.value = fn(node.value)
+	node.set = true
+	return true
+}
+
+//lookup value of the most specific network containing ip
+func (t *ipTrie) lookup(ip net.IP) (interface{}, bool) {
+	return t.lookupFunc(ip, nil)
+}
+
+//lookupFunc value of the most specific network containing ip whose value matches, nil match matches any value
+func (t *ipTrie) lookupFunc(ip net.IP, match func(interface{}) bool) (interface{}, bool) {
+	if t == nil || t.size == 0 {
+		return nil, false
+	}
+	node, ip := t.root(ip)
+	var value interface{}
+	found := false
+	for i := 0; node != nil; i++ {
+		if node.set && (match == nil || match(node.value)) {
+			value, found = node.value, true
+		}
+		if i == len(ip)*8 {
+			break
+		}
+		node = node.children[ipBit(ip, i)]
+	}
+	return value, found
+}
+
+//contains whether ip is in any network of trie
+func (t *ipTrie) contains(ip net.IP) bool {
+	_, ok := t.lookup(ip)
+	return ok
+}
+
+func ipBit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
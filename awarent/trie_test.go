@@ -0,0 +1,44 @@
+package awarent
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func BenchmarkTrieLookup(b *testing.B) {
+	trie := newIPTrie()
+	for i := 0; i < 100000; i++ {
+		trie.insertEntry(fmt.Sprintf("10.%d.%d.0/24", i/256%256, i%256), true, true)
+	}
+	ip := net.ParseIP("10.200.100.7")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.lookup(ip)
+	}
+}
+
+func TestTrieFamilies(t *testing.T) {
+	trie := newIPTrie()
+	trie.insertEntry("::/0", "v6", true)
+	trie.insertEntry("::ffff:198.51.100.0/120", "mapped", true)
+	cases := []struct {
+		ip   string
+		want interface{}
+	}{
+		{"192.0.2.1", nil},
+		{"2001:db8::1", "v6"},
+		{"198.51.100.7", "mapped"},
+		{"::ffff:198.51.100.7", "mapped"},
+	}
+	for _, c := range cases {
+		got, _ := trie.lookup(net.ParseIP(c.ip))
+		if got != c.want {
+			t.Errorf("lookup %s = %v, want %v", c.ip, got, c.want)
+		}
+	}
+	trie.insertEntry("0.0.0.0/0", "v4", true)
+	if got, _ := trie.lookup(net.ParseIP("2001:db8::1")); got != "v6" {
+		t.Errorf("ipv4 network should not contain ipv6 address, got %v", got)
+	}
+}
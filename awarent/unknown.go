@@ -0,0 +1,124 @@
+package awarent
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//actions of unknown resource policy
+const (
+	UnknownAllow  = "allow"
+	UnknownReject = "reject"
+	UnknownLimit  = "limit"
+)
+
+//UnknownResourceOptions policy of protected requests whose resource(cid) is empty or has no flow control rule.
+//Action is allow(default), reject with 403, or limit each client ip to Threshold requests per second
+//with Burst(Threshold rounded up by default), exceeded requests are rejected with 429
+type UnknownResourceOptions struct {
+	Action    string  `yaml:"action"`
+	Threshold float64 `yaml:"threshold"`
+	Burst     int     `yaml:"burst"`
+}
+
+func (opts *UnknownResourceOptions) burst() float64 {
+	if opts.Burst > 0 {
+		return float64(opts.Burst)
+	}
+	return math.Max(1, math.Ceil(opts.Threshold))
+}
+
+//ipLimiter token buckets of client ips, the zero value is ready to use. state is kept across rule reloads
+type ipLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+const limiterSweepInterval = time.Minute
+
+//allow take a token of ip, false returned if bucket is empty
+func (l *ipLimiter) allow(ip string, rate, burst float64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	if now.Sub(l.swept) >= limiterSweepInterval {
+		l.swept = now
+		for key, b := range l.buckets {
+			//idle bucket is full again
+			if now.Sub(b.last).Seconds()*rate >= burst {
+				delete(l.buckets, key)
+			}
+		}
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//unknownResource whether cid of request is empty or has no flow control rule
+func (s *ruleSnapshot) unknownResource(c *gin.Context, cid string) bool {
+	if len(cid) == 0 {
+		return true
+	}
+	route, _ := s.rule.matchRoute(c)
+	_, ok := s.rule.option(route, cid)
+	return !ok
+}
+
+//checkUnknownResource apply unknown resource policy to request under flow control, false returned if request is rejected
+func (a *Awarent) checkUnknownResource(c *gin.Context) bool {
+	s := a.requestSnapshot(c)
+	opts := &s.rule.UnknownResource
+	action := strings.ToLower(opts.Action)
+	if action == "" || action == UnknownAllow || !s.flowProtected(c) {
+		return true
+	}
+	ip := a.clientIP(c)
+	if s.bypass.bypass(c, ip) {
+		return true
+	}
+	cid := s.flowResource(c)
+	if !s.unknownResource(c, cid) {
+		return true
+	}
+	status := 0
+	switch action {
+	case UnknownReject:
+		status = http.StatusForbidden
+	case UnknownLimit:
+		if opts.Threshold > 0 && a.limiter.allow(ip, opts.Threshold, opts.burst(), time.Now()) {
+			return true
+		}
+		status = http.StatusTooManyRequests
+	default:
+		return true
+	}
+	c.AbortWithStatus(status)
+	lvs := []string{fmt.Sprintf("%d", status), c.Request.URL.Path, s.resourceName(c, cid)}
+	blockCount.WithLabelValues(lvs...).Inc()
+	reqCount.WithLabelValues(lvs...).Inc()
+	return false
+}
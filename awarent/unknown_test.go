@@ -0,0 +1,69 @@
+package awarent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIPLimiter(t *testing.T) {
+	var l ipLimiter
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !l.allow("192.0.2.1", 1, 2, now) {
+			t.Fatalf("request %d within burst should be allowed", i)
+		}
+	}
+	if l.allow("192.0.2.1", 1, 2, now) {
+		t.Errorf("request over burst should be limited")
+	}
+	if !l.allow("192.0.2.2", 1, 2, now) {
+		t.Errorf("other ip has its own bucket")
+	}
+	if !l.allow("192.0.2.1", 1, 2, now.Add(time.Second)) {
+		t.Errorf("bucket should be refilled")
+	}
+}
+
+func TestUnknownResource(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rule := Rule{
+		ResourceParam:    "cid",
+		FlowControlRules: []FlowControlOption{{Resource: "bigdata", Threshold: 100}},
+		IPFilterRules:    FilterOptions{URLPath: "/q", URLParam: "cid"},
+		UnknownResource:  UnknownResourceOptions{Action: UnknownReject},
+	}
+	a := &Awarent{}
+	a.setRule(rule)
+	e := gin.New()
+	e.Use(a.Sentinel())
+	e.GET("/q", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	serve := func(url string) int {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.RemoteAddr = "192.0.2.1:1"
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := serve("/q?cid=bigdata"); code != http.StatusOK {
+		t.Errorf("known resource got %d, want 200", code)
+	}
+	if code := serve("/q?cid=unknown"); code != http.StatusForbidden {
+		t.Errorf("unknown resource got %d, want 403", code)
+	}
+	if code := serve("/q"); code != http.StatusForbidden {
+		t.Errorf("empty resource got %d, want 403", code)
+	}
+
+	rule.UnknownResource = UnknownResourceOptions{Action: UnknownLimit, Threshold: 1, Burst: 2}
+	a.setRule(rule)
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, code := range want {
+		if got := serve("/q?cid=unknown"); got != code {
+			t.Errorf("limited request %d got %d, want %d", i, got, code)
+		}
+	}
+}
@@ -5,7 +5,9 @@ go 1.15
 require (
 	github.com/alibaba/sentinel-golang v1.0.0-M1
 	github.com/gin-gonic/gin v1.6.3
+	github.com/lestrrat/go-file-rotatelogs v0.0.0-20180223000712-d3151e2a480f
 	github.com/nacos-group/nacos-sdk-go v1.0.0
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/prometheus/client_golang v1.1.0
 	gopkg.in/yaml.v2 v2.2.8
 )
@@ -55,10 +55,18 @@ func main() {
 	})
 	e := gin.New()
 	e.Use(gin.Recovery())
+	//gin 使用 维护模式middleware，需在其他awarent middleware之前
+	e.Use(aware.Maintenance())
+	//gin 使用 API key认证middleware，需在IP过滤之前
+	e.Use(aware.APIKeyAuth())
+	//gin 使用 请求签名校验middleware
+	e.Use(aware.SignatureAuth())
 	//gin 使用 IP过滤middleware
 	e.Use(aware.IPFilter())
 	//gin 使用 限流middleware
 	e.Use(aware.Sentinel())
+	//gin 使用 故障注入middleware，规则中开启后生效
+	e.Use(aware.FaultInjection())
 	e.GET("/", func(c *gin.Context) {
 		c.String(200, "OK")
 	})
@@ -71,7 +79,7 @@ func main() {
 		r := rand.Intn(10)
 		time.Sleep(time.Duration(r) * time.Millisecond)
 		cid := c.Query("cid")
-		log.Printf("ip:%s,cid=%s", cid, c.ClientIP())
+		log.Printf("ip:%s,cid=%s", awarent.ClientIP(c), cid)
 		c.String(200, "%s", time.Now().Format(time.RFC3339))
 	})
 	srv := &http.Server{
@@ -84,7 +92,19 @@ func main() {
 			fmt.Printf("start server error:%v\n", err)
 		}
 	}()
-	quit := make(chan os.Signal)
+	//管理接口不挂在业务engine上，只监听本机地址
+	admin := gin.New()
+	//自动封禁列表和解封
+	admin.GET("/awarent/bans", aware.BanHandler)
+	admin.DELETE("/awarent/bans", aware.BanHandler)
+	//决策解释
+	admin.GET("/awarent/explain", aware.ExplainHandler)
+	go func() {
+		if err := http.ListenAndServe("127.0.0.1:8081", admin); err != nil {
+			fmt.Printf("start admin server error:%v\n", err)
+		}
+	}()
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	<-quit
 	//